@@ -1,20 +1,14 @@
 package main
 
 import (
-	"archive/zip"
 	"bytes"
-	_ "embed"
-	"errors"
 	"fmt"
-	"go/parser"
-	"go/token"
 	"io/fs"
+	"mime"
 	"net/http"
 	"path"
 	"slices"
-	"strconv"
 	"strings"
-	"time"
 
 	"github.com/crhntr/txtarfmt"
 	"golang.org/x/mod/modfile"
@@ -22,10 +16,14 @@ import (
 )
 
 type MemoryDirectory struct {
+	Name       string
 	Archive    *txtar.Archive
 	MultiFile  bool
 	ActiveFile string
 	OpenFiles  []string
+	// MainPackage is the relative path, like "." or "./cmd/server", of the
+	// main package to build. It is empty when the default should be used.
+	MainPackage string
 }
 
 func (dir MemoryDirectory) OpenFilesString() string { return strings.Join(dir.OpenFiles, ",") }
@@ -37,6 +35,7 @@ func (dir *MemoryDirectory) normalizeIDEState() {
 	if dir.Archive == nil || len(dir.Archive.Files) == 0 {
 		dir.ActiveFile = ""
 		dir.OpenFiles = nil
+		dir.MainPackage = ""
 		return
 	}
 	exists := func(name string) bool {
@@ -63,14 +62,56 @@ func (dir *MemoryDirectory) normalizeIDEState() {
 	if !seen[dir.ActiveFile] {
 		dir.OpenFiles = append(dir.OpenFiles, dir.ActiveFile)
 	}
+	if !slices.Contains(dir.MainPackages(), dir.MainPackage) {
+		dir.MainPackage = ""
+	}
+}
+
+// MainPackages returns the sorted relative paths of the directories holding a
+// main package, like "." and "./cmd/server".
+func (dir MemoryDirectory) MainPackages() []string {
+	var packages []string
+	if dir.Archive == nil {
+		return packages
+	}
+	for _, file := range dir.Archive.Files {
+		if path.Ext(file.Name) != ".go" || strings.HasSuffix(file.Name, "_test.go") || !isPackageMain(file.Data) {
+			continue
+		}
+		pkg := "."
+		if d := path.Dir(file.Name); d != "." {
+			pkg = "./" + d
+		}
+		if !slices.Contains(packages, pkg) {
+			packages = append(packages, pkg)
+		}
+	}
+	slices.Sort(packages)
+	return packages
+}
+
+// mainPackage returns the package to build: the selected MainPackage, the
+// module root when it is a main package, or else the first main package.
+func (dir MemoryDirectory) mainPackage() string {
+	if dir.MainPackage != "" {
+		return dir.MainPackage
+	}
+	packages := dir.MainPackages()
+	if len(packages) == 0 || slices.Contains(packages, ".") {
+		return "."
+	}
+	return packages[0]
 }
 
 func readMemoryDirectory(req *http.Request) (MemoryDirectory, error) {
-	_ = req.ParseMultipartForm(maxBodyBytes)
-	defer closeAndIgnoreError(req.Body)
+	if err := parseRequestForm(req); err != nil {
+		return MemoryDirectory{}, err
+	}
 
 	toggleView := req.Header.Get("hx-trigger") == "toggle-view"
+	name := req.Form.Get("project-name")
 	activeFile := req.Form.Get("active-file")
+	mainPackage := req.Form.Get("main-package")
 	var openFiles []string
 	if v := req.Form.Get("open-tabs"); v != "" {
 		openFiles = strings.Split(v, ",")
@@ -78,27 +119,22 @@ func readMemoryDirectory(req *http.Request) (MemoryDirectory, error) {
 
 	if content := req.Form.Get("txtar-content"); content != "" {
 		archive := txtar.Parse([]byte(content))
-		for _, file := range archive.Files {
-			if !isPermittedFile(file.Name) {
-				return MemoryDirectory{}, fmt.Errorf("file not permitted: %s", file.Name)
-			}
-		}
 		multiFile := false
 		if toggleView {
 			multiFile = true
 		}
-		dir := MemoryDirectory{Archive: archive, MultiFile: multiFile, ActiveFile: activeFile, OpenFiles: openFiles}
-		expandNestedTxtar(&dir)
+		dir := MemoryDirectory{Name: name, Archive: archive, MultiFile: multiFile, ActiveFile: activeFile, OpenFiles: openFiles, MainPackage: mainPackage}
+		if err := dir.expandAndCheckArchive(); err != nil {
+			return MemoryDirectory{}, err
+		}
 		dir.normalizeIDEState()
+		auditArchive(req.Context(), dir.Archive)
 		return dir, nil
 	}
 
 	filenames := req.Form["filename"]
 	archive := &txtar.Archive{Files: make([]txtar.File, 0, len(filenames))}
 	for _, filename := range filenames {
-		if !isPermittedFile(filename) {
-			return MemoryDirectory{}, fmt.Errorf("file not permitted: %s", filename)
-		}
 		archive.Files = append(archive.Files, txtar.File{
 			Name: filename,
 			Data: []byte(req.Form.Get(filename)),
@@ -115,22 +151,29 @@ func readMemoryDirectory(req *http.Request) (MemoryDirectory, error) {
 	})
 	slices.Sort(openFiles)
 
-	dir := MemoryDirectory{Archive: archive, MultiFile: multiFile, ActiveFile: activeFile, OpenFiles: openFiles}
-	expandNestedTxtar(&dir)
+	dir := MemoryDirectory{Name: name, Archive: archive, MultiFile: multiFile, ActiveFile: activeFile, OpenFiles: openFiles, MainPackage: mainPackage}
+	if err := dir.expandAndCheckArchive(); err != nil {
+		return MemoryDirectory{}, err
+	}
 	dir.normalizeIDEState()
+	auditArchive(req.Context(), dir.Archive)
 	return dir, nil
 }
 
-func newMemoryDirectoryFromFS(r fs.FS) (MemoryDirectory, error) {
-	dir := MemoryDirectory{
+// newMemoryDirectoryFromFS reads the permitted files in r. The names of files
+// that are not permitted are returned in rejected rather than loaded. An
+// archive exceeding the limits of checkArchive is an error.
+func newMemoryDirectoryFromFS(r fs.FS) (dir MemoryDirectory, rejected []string, err error) {
+	dir = MemoryDirectory{
 		Archive:   new(txtar.Archive),
 		MultiFile: true,
 	}
-	err := fs.WalkDir(r, ".", func(p string, d fs.DirEntry, err error) error {
+	err = fs.WalkDir(r, ".", func(p string, d fs.DirEntry, err error) error {
 		if err != nil || d.IsDir() {
 			return err
 		}
 		if !isPermittedFile(p) {
+			rejected = append(rejected, p)
 			return nil
 		}
 		fileBuf, err := fs.ReadFile(r, p)
@@ -143,11 +186,14 @@ func newMemoryDirectoryFromFS(r fs.FS) (MemoryDirectory, error) {
 		})
 		return nil
 	})
-	if err == nil {
-		expandNestedTxtar(&dir)
-		dir.normalizeIDEState()
+	if err != nil {
+		return dir, rejected, err
+	}
+	if err := dir.expandAndCheckArchive(); err != nil {
+		return dir, rejected, err
 	}
-	return dir, err
+	dir.normalizeIDEState()
+	return dir, rejected, nil
 }
 
 func (dir MemoryDirectory) Txtar() string { return string(txtar.Format(dir.Archive)) }
@@ -156,7 +202,21 @@ func (dir *MemoryDirectory) fmt() error {
 	return txtarfmt.Archive(dir.Archive, txtarfmt.Configuration{})
 }
 
-func expandNestedTxtar(dir *MemoryDirectory) {
+// expandAndCheckArchive expands nested txtar files and then checks the
+// archive with checkArchive. Every entry point loading an archive calls it
+// before the files are used.
+func (dir *MemoryDirectory) expandAndCheckArchive() error {
+	if err := expandNestedTxtar(dir); err != nil {
+		return err
+	}
+	return checkArchive(dir.Archive)
+}
+
+// expandNestedTxtar replaces each .txtar file with the files it contains, in
+// a directory named like the file without its extension. Only one level is
+// expanded: a nested archive containing a txtar file is an error, as is an
+// expansion with more than maxArchiveFiles files.
+func expandNestedTxtar(dir *MemoryDirectory) error {
 	var expanded []txtar.File
 	for _, file := range dir.Archive.Files {
 		if path.Ext(file.Name) != ".txtar" {
@@ -166,117 +226,64 @@ func expandNestedTxtar(dir *MemoryDirectory) {
 		nested := txtar.Parse(file.Data)
 		dirPath := strings.TrimSuffix(file.Name, ".txtar")
 		for _, nestedFile := range nested.Files {
+			if path.Ext(nestedFile.Name) == ".txtar" {
+				return &ArchiveError{File: path.Join(dirPath, nestedFile.Name), Err: errNestedTxtarInTxtar}
+			}
+			// Check the name as written, since the join below would clean
+			// away elements like "..".
+			if !fs.ValidPath(nestedFile.Name) {
+				return &ArchiveError{File: file.Name, Err: errInvalidPath, Detail: fmt.Sprintf("contains %q", nestedFile.Name)}
+			}
 			expanded = append(expanded, txtar.File{
 				Name: path.Join(dirPath, nestedFile.Name),
 				Data: nestedFile.Data,
 			})
 		}
+		if len(expanded) > maxArchiveFiles {
+			return &ArchiveError{Err: errTooManyFiles, Detail: fmt.Sprintf("more than %d files after expanding %s", maxArchiveFiles, file.Name)}
+		}
 	}
 	dir.Archive.Files = expanded
+	return nil
 }
 
+// ServeHTTP responds with the directory as a downloadable archive in the
+// format chosen by negotiateDownloadFormat.
 func (dir *MemoryDirectory) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	dirFS, err := txtar.FS(dir.Archive)
-	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
-		return
-	}
+	format := downloadFormats[negotiateDownloadFormat(req)]
 	var buf bytes.Buffer
-	output := zip.NewWriter(&buf)
-	if err = output.AddFS(dirFS); err != nil {
+	if err := format.write(&buf, dir.Archive); err != nil {
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
-	_ = output.Flush()
-	_ = output.Close()
-	res.Header().Set("Content-Disposition", "attachment")
-	res.Header().Set("Content-Type", "application/zip")
-	http.ServeContent(res, req, "playground.zip", time.Time{}, bytes.NewReader(buf.Bytes()))
+	filename := downloadFilename(dir.Name) + format.extension
+	res.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	res.Header().Set("Content-Type", format.contentType)
+	http.ServeContent(res, req, filename, archiveModTime, bytes.NewReader(buf.Bytes()))
 }
 
-//go:embed assets/module_allow_list.txt
-var permittedModulesString string
-
 type Module struct {
 	File   txtar.File
 	Module modfile.File
 }
 
+// newModule parses a go.mod file. Its requirements and replace directives are
+// checked by Policy.Check, which knows the other modules in the archive.
 func newModule(file txtar.File) (Module, error) {
 	module, err := modfile.Parse(file.Name, file.Data, nil)
 	if err != nil {
 		return Module{}, err
 	}
-	if len(module.Replace) != 0 {
-		return Module{}, fmt.Errorf("replace directive is not allowed in module")
-	}
-	allowed := strings.Split(permittedModulesString, "\n")
-	allowed = slices.DeleteFunc(allowed, func(s string) bool {
-		return s == ""
-	})
-	for _, requirement := range module.Require {
-		if requirement.Indirect {
-			continue
-		}
-		if !slices.Contains(allowed, requirement.Mod.Path) {
-			return Module{}, fmt.Errorf("module %s not permitted", requirement.Mod.Path)
-		}
-	}
 	return Module{
 		File:   file,
 		Module: *module,
 	}, nil
 }
 
-func checkImports(file txtar.File) error {
-	var fileSet token.FileSet
-	f, err := parser.ParseFile(&fileSet, file.Name, file.Data, parser.ImportsOnly)
-	if err != nil {
-		return fmt.Errorf("failed to parse main.go: %w", err)
-	}
-	allowedModules := strings.Split(permittedModulesString, "\n")
-	allowedModules = slices.DeleteFunc(allowedModules, func(s string) bool {
-		return s == ""
-	})
-
-	for _, spec := range f.Imports {
-		pkgPath, _ := strconv.Unquote(spec.Path.Value)
-		if slices.Index(permittedPackages(), pkgPath) >= 0 {
-			continue
-		}
-		if slices.ContainsFunc(allowedModules, func(modName string) bool {
-			return strings.HasPrefix(pkgPath, modName+"/")
-		}) {
-			continue
-		}
-		return fmt.Errorf("package %q not permitted", pkgPath)
-	}
-	return nil
-}
-
-//go:embed assets/import_allow_list.txt
-var permittedPackagesString string
-
-func permittedPackages() []string {
-	return removeZeros(strings.Split(permittedPackagesString, "\n"))
-}
-
-func checkDependencies(archive *txtar.Archive) ([]Module, error) {
-	var modules []Module
-	for _, file := range archive.Files {
-		base := path.Base(file.Name)
-		switch {
-		case base == "go.mod":
-			mod, err := newModule(file)
-			if err != nil {
-				return nil, errors.Join(err, err)
-			}
-			modules = append(modules, mod)
-		case path.Ext(base) == ".go":
-			if err := checkImports(file); err != nil {
-				return nil, fmt.Errorf("failed in %s: %w", file.Name, err)
-			}
-		}
+// Path returns the module path declared in the go.mod file.
+func (mod Module) Path() string {
+	if mod.Module.Module == nil {
+		return ""
 	}
-	return modules, nil
+	return mod.Module.Module.Mod.Path
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+func TestMemoryDirectory_MainPackages(t *testing.T) {
+	dir := MemoryDirectory{
+		Archive: txtar.Parse([]byte(`-- go.mod --
+module example.com
+-- cmd/b/main.go --
+package main
+-- cmd/a/main.go --
+package main
+-- cmd/a/main_test.go --
+package main
+-- internal/lib/lib.go --
+package lib
+`)),
+		MainPackage: "./cmd/b",
+	}
+	dir.normalizeIDEState()
+
+	if got, want := dir.MainPackages(), []string{"./cmd/a", "./cmd/b"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := dir.mainPackage(); got != "./cmd/b" {
+		t.Errorf("got selected package %q", got)
+	}
+
+	dir.MainPackage = "-toolexec=sh"
+	dir.normalizeIDEState()
+	if got := dir.mainPackage(); got != "./cmd/a" {
+		t.Errorf("expected unknown package to fall back to the first main package, got %q", got)
+	}
+
+	dir.Archive.Files = append(dir.Archive.Files, txtar.File{Name: "main.go", Data: []byte("package main\n")})
+	if got := dir.mainPackage(); got != "." {
+		t.Errorf("expected the module root by default, got %q", got)
+	}
+}
+
+func Test_checkDependencies_localModule(t *testing.T) {
+	archive := txtar.Parse([]byte(`-- go.mod --
+module example.com/tools
+-- cmd/a/main.go --
+package main
+
+import "example.com/tools/internal/lib"
+-- internal/lib/lib.go --
+package lib
+`))
+	if _, err := checkDependencies(archive); err != nil {
+		t.Fatal(err)
+	}
+
+	archive.Files = append(archive.Files, txtar.File{Name: "cmd/b/main.go", Data: []byte(`package main
+
+import "example.com/other"
+`)})
+	if _, err := checkDependencies(archive); err == nil {
+		t.Fatal("expected an import outside the local module to fail")
+	}
+}
+
+func Test_checkDependencies_workspace(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		archive string
+		wantErr string
+	}{
+		{
+			name: "workspace",
+			archive: `-- go.work --
+go 1.25
+
+use (
+	./app
+	./lib
+)
+-- app/go.mod --
+module example.com/app
+
+require example.com/lib v0.0.0
+-- app/main.go --
+package main
+
+import "example.com/lib"
+-- lib/go.mod --
+module example.com/lib
+-- lib/lib.go --
+package lib
+`,
+		},
+		{
+			name: "local replace",
+			archive: `-- go.mod --
+module example.com/app
+
+require example.com/lib v0.0.0
+
+replace example.com/lib => ./lib
+-- lib/go.mod --
+module example.com/lib
+`,
+		},
+		{
+			name: "replace with remote module",
+			archive: `-- go.mod --
+module example.com/app
+
+replace github.com/crhntr/dom => github.com/example/dom v1.0.0
+`,
+			wantErr: "must point to a module directory",
+		},
+		{
+			name: "replace outside the archive",
+			archive: `-- go.mod --
+module example.com/app
+
+replace example.com/lib => ../lib
+`,
+			wantErr: "not a module in the project",
+		},
+		{
+			name: "replace with another local module",
+			archive: `-- go.mod --
+module example.com/app
+
+replace github.com/crhntr/dom => ./lib
+-- lib/go.mod --
+module example.com/lib
+`,
+			wantErr: "points to module example.com/lib",
+		},
+		{
+			name: "use outside the archive",
+			archive: `-- go.work --
+go 1.25
+
+use ../app
+-- go.mod --
+module example.com/app
+`,
+			wantErr: "not a module in the project",
+		},
+		{
+			name: "requirement in nested module",
+			archive: `-- go.work --
+go 1.25
+
+use ./lib
+-- lib/go.mod --
+module example.com/lib
+
+require example.com/other v1.0.0
+`,
+			wantErr: "module example.com/other not permitted",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := checkDependencies(txtar.Parse([]byte(tt.archive)))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
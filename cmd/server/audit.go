@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/txtar"
+)
+
+const (
+	defaultAuditRetention = 30 * 24 * time.Hour
+	// maxAuditErrorBytes limits the response body kept as the error of a
+	// failed request.
+	maxAuditErrorBytes = 512
+)
+
+// auditLog writes an auditEvent for each audited request, as a JSON line, to
+// a file per UTC day named like "audit-2006-01-02.jsonl" in dir. Files and
+// stored archives older than retention are removed. When keepArchives is
+// set, each archive is stored in dir/archives, named by its hash, so it can
+// be retrieved later. A nil *auditLog audits nothing.
+type auditLog struct {
+	dir           string
+	retention     time.Duration
+	keepArchives  bool
+	trustedHeader string
+	now           func() time.Time
+
+	mu   sync.Mutex
+	day  string
+	file *os.File
+}
+
+// auditEvent is a line of the audit log.
+type auditEvent struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	User   string    `json:"user,omitempty"`
+	Client string    `json:"client"`
+	// Source is the reference of an imported project.
+	Source string `json:"source,omitempty"`
+	// Archive is the hex SHA-256 of the project in txtar format.
+	Archive string `json:"archive,omitempty"`
+	Files   int    `json:"files,omitempty"`
+	// Modules lists the requirements, as path@version, of the go.mod files.
+	Modules    []string `json:"modules,omitempty"`
+	DurationMS int64    `json:"duration_ms"`
+	Status     int      `json:"status"`
+	// Outcome is "ok", "error", or what a handler set with auditOutcome,
+	// like "build failed".
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+func newAuditLog(dir string, retention time.Duration, keepArchives bool, trustedHeader string) (*auditLog, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "archives"), 0o700); err != nil {
+		return nil, err
+	}
+	audit := &auditLog{
+		dir:           dir,
+		retention:     retention,
+		keepArchives:  keepArchives,
+		trustedHeader: trustedHeader,
+		now:           time.Now,
+	}
+	audit.prune()
+	return audit, nil
+}
+
+// auditRecord collects what handlers know about an audited request.
+type auditRecord struct {
+	// archive is the project in txtar format when auditArchive was called,
+	// since handlers like /fmt change the archive afterwards.
+	archive []byte
+	files   int
+	modules []string
+	source  string
+	outcome string
+}
+
+type auditRecordKey struct{}
+
+// auditArchive records the project an audited request works on.
+func auditArchive(ctx context.Context, archive *txtar.Archive) {
+	if record, ok := ctx.Value(auditRecordKey{}).(*auditRecord); ok {
+		record.archive = txtar.Format(archive)
+		record.files = len(archive.Files)
+		record.modules = requiredModules(archive)
+	}
+}
+
+// auditSource records the reference of a project an audited request
+// imported.
+func auditSource(ctx context.Context, src string) {
+	if record, ok := ctx.Value(auditRecordKey{}).(*auditRecord); ok {
+		record.source = src
+	}
+}
+
+// auditOutcome records an outcome that the status code does not show, like
+// a run whose build failed.
+func auditOutcome(ctx context.Context, outcome string) {
+	if record, ok := ctx.Value(auditRecordKey{}).(*auditRecord); ok {
+		record.outcome = outcome
+	}
+}
+
+// record logs each request to next as action.
+func (audit *auditLog) record(action string, next http.Handler) http.Handler {
+	if audit == nil {
+		return next
+	}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		start := audit.now()
+		record := new(auditRecord)
+		rec := &auditResponseWriter{ResponseWriter: res, status: http.StatusOK}
+		next.ServeHTTP(rec, req.WithContext(context.WithValue(req.Context(), auditRecordKey{}, record)))
+
+		event := auditEvent{
+			Time:       start.UTC(),
+			Action:     action,
+			User:       userFromContext(req.Context()),
+			Client:     clientAddress(req, audit.trustedHeader),
+			Source:     record.source,
+			DurationMS: audit.now().Sub(start).Milliseconds(),
+			Status:     rec.status,
+			Outcome:    record.outcome,
+		}
+		if event.Outcome == "" {
+			event.Outcome = "ok"
+			if rec.status >= http.StatusBadRequest {
+				event.Outcome = "error"
+			}
+		}
+		if rec.status >= http.StatusBadRequest {
+			event.Error = strings.TrimSpace(rec.errorBody.String())
+		}
+		if record.archive != nil {
+			sum := sha256.Sum256(record.archive)
+			event.Archive = hex.EncodeToString(sum[:])
+			event.Files = record.files
+			event.Modules = record.modules
+			if audit.keepArchives {
+				audit.storeArchive(event.Archive, record.archive)
+			}
+		}
+		audit.write(event)
+	})
+}
+
+// requiredModules returns the requirements of the go.mod files in archive.
+func requiredModules(archive *txtar.Archive) []string {
+	var modules []string
+	for _, file := range archive.Files {
+		if path.Base(file.Name) != "go.mod" {
+			continue
+		}
+		mf, err := modfile.ParseLax(file.Name, file.Data, nil)
+		if err != nil {
+			continue
+		}
+		for _, req := range mf.Require {
+			modules = append(modules, req.Mod.String())
+		}
+	}
+	return modules
+}
+
+func (audit *auditLog) write(event auditEvent) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Println("failed to encode audit event:", err)
+		return
+	}
+	line = append(line, '\n')
+
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	day := event.Time.Format(time.DateOnly)
+	if audit.file == nil || audit.day != day {
+		if audit.file != nil {
+			_ = audit.file.Close()
+		}
+		f, err := os.OpenFile(filepath.Join(audit.dir, "audit-"+day+".jsonl"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			log.Println("failed to open audit log:", err)
+			audit.file = nil
+			return
+		}
+		audit.file, audit.day = f, day
+		audit.prune()
+	}
+	if _, err := audit.file.Write(line); err != nil {
+		log.Println("failed to write audit log:", err)
+	}
+}
+
+func (audit *auditLog) storeArchive(hash string, content []byte) {
+	filename := filepath.Join(audit.dir, "archives", hash+".txtar")
+	if err := os.WriteFile(filename, content, 0o600); err != nil {
+		log.Println("failed to store audited archive:", err)
+		return
+	}
+	// Refresh the time of an archive seen before so it is kept for the
+	// retention period after its last use.
+	now := audit.now()
+	_ = os.Chtimes(filename, now, now)
+}
+
+// prune removes log files and archives older than the retention period.
+func (audit *auditLog) prune() {
+	if audit.retention <= 0 {
+		return
+	}
+	cutoff := audit.now().Add(-audit.retention)
+	for _, pattern := range []string{"audit-*.jsonl", filepath.Join("archives", "*.txtar")} {
+		matches, _ := filepath.Glob(filepath.Join(audit.dir, pattern))
+		for _, filename := range matches {
+			info, err := os.Stat(filename)
+			if err != nil || !info.ModTime().Before(cutoff) {
+				continue
+			}
+			if err := os.Remove(filename); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Println("failed to remove expired audit file:", err)
+			}
+		}
+	}
+}
+
+// auditResponseWriter keeps the status code and the start of the body of
+// error responses.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	errorBody   bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(buf []byte) (int, error) {
+	w.wroteHeader = true
+	if w.status >= http.StatusBadRequest && w.errorBody.Len() < maxAuditErrorBytes {
+		w.errorBody.Write(buf[:min(len(buf), maxAuditErrorBytes-w.errorBody.Len())])
+	}
+	return w.ResponseWriter.Write(buf)
+}
+
+func (w *auditResponseWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/txtar"
+)
+
+func Test_auditLog(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	expired := filepath.Join(dir, "audit-2025-11-01.jsonl")
+	if err := os.WriteFile(expired, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(expired, now.Add(-60*24*time.Hour), now.Add(-60*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	audit, err := newAuditLog(dir, 30*24*time.Hour, true, "X-Forwarded-For")
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.now = func() time.Time { return now }
+	audit.prune()
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("expected the expired log to be removed, got %v", err)
+	}
+
+	handler := audit.record("run", http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		dir, err := readMemoryDirectory(req)
+		if err != nil {
+			writeRequestError(res, req, err)
+			return
+		}
+		// Handlers like /fmt change the archive after it is audited.
+		dir.Archive.Files[0].Data = []byte("module example.com/changed\n")
+		now = now.Add(1500 * time.Millisecond)
+		auditOutcome(req.Context(), "build failed")
+	}))
+	archive := &txtar.Archive{Files: []txtar.File{
+		{Name: "go.mod", Data: []byte("module example.com/hello\n\ngo 1.26\n\nrequire github.com/crhntr/dom v0.1.0\n")},
+		{Name: "main.go", Data: []byte("package main\n\nfunc main() {}\n")},
+	}}
+	serve := func(content string) {
+		req := httptest.NewRequest(http.MethodPost, "/go/run", strings.NewReader(url.Values{"txtar-content": {content}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-For", "203.0.113.1")
+		req = req.WithContext(contextWithUser(req.Context(), "gopher"))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	serve(string(txtar.Format(archive)))
+	serve("-- ../main.go --\npackage main\n")
+
+	sources := new(Sources)
+	sources.Register("share:", SourceFunc(shareSource))
+	encoded, err := encodeShare(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/?share="+encoded, nil)
+	audit.record("import", handleIndexPage("1.26", nil, sources)).ServeHTTP(httptest.NewRecorder(), req)
+
+	f, err := os.Open(filepath.Join(dir, "audit-2026-01-02.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+	var events []auditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event auditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+
+	run := events[0]
+	if run.Action != "run" || run.User != "gopher" || run.Client != "203.0.113.1" || run.Outcome != "build failed" || run.DurationMS != 1500 {
+		t.Errorf("unexpected event %+v", run)
+	}
+	if run.Files != 2 || len(run.Modules) != 1 || run.Modules[0] != "github.com/crhntr/dom@v0.1.0" {
+		t.Errorf("unexpected project in event %+v", run)
+	}
+	stored, err := os.ReadFile(filepath.Join(dir, "archives", run.Archive+".txtar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored) != string(txtar.Format(archive)) {
+		t.Errorf("unexpected stored archive %q", stored)
+	}
+
+	invalid := events[1]
+	if invalid.Status != http.StatusBadRequest || invalid.Outcome != "error" || invalid.Archive != "" || !strings.Contains(invalid.Error, "../main.go") {
+		t.Errorf("unexpected event %+v", invalid)
+	}
+
+	imported := events[2]
+	if imported.Action != "import" || imported.Source != "share:"+encoded || imported.Archive != run.Archive {
+		t.Errorf("expected the shared project in event %+v", imported)
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+)
+
+const (
+	sessionCookieName    = "playground-session"
+	oidcStateCookieName  = "playground-oidc-state"
+	sessionDuration      = 12 * time.Hour
+	oidcLoginDuration    = 10 * time.Minute
+	defaultAuthProxyUser = "X-Forwarded-User"
+)
+
+// publicPathPrefixes are served without authentication: the static assets
+// and the module proxy go subprocesses download from. The run iframe needs
+// nothing else, since its document and program are inlined in the response
+// to POST /go/run.
+var publicPathPrefixes = []string{"/assets/", "/goproxy/"}
+
+// loginPathPrefix is also served without authentication when the
+// authenticator is an http.Handler serving its login routes there.
+const loginPathPrefix = "/auth/"
+
+// authenticator identifies the user making a request. See requireAuth.
+type authenticator interface {
+	// authenticate returns the user making req, or "" when the request does
+	// not carry valid credentials.
+	authenticate(req *http.Request) string
+	// challenge responds to a request without valid credentials.
+	challenge(res http.ResponseWriter, req *http.Request)
+}
+
+// requireAuth serves requests to next once auth identifies the user, who is
+// then available from userFromContext for rate limiting and audit logs.
+func requireAuth(auth authenticator, next http.Handler) http.Handler {
+	public := publicPathPrefixes
+	if _, ok := auth.(http.Handler); ok {
+		public = append(slices.Clip(public), loginPathPrefix)
+	}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		for _, prefix := range public {
+			if strings.HasPrefix(req.URL.Path, prefix) {
+				next.ServeHTTP(res, req)
+				return
+			}
+		}
+		user := auth.authenticate(req)
+		if user == "" {
+			auth.challenge(res, req)
+			return
+		}
+		next.ServeHTTP(res, req.WithContext(contextWithUser(req.Context(), user)))
+	})
+}
+
+// authConfig selects and configures the authenticator.
+type authConfig struct {
+	// Mode is "" for no authentication, "basic", "proxy", or "oidc".
+	Mode string
+
+	// HtpasswdFile holds the users and bcrypt password hashes for "basic".
+	HtpasswdFile string
+	// ProxyHeader holds the user set by the authenticating proxy for
+	// "proxy".
+	ProxyHeader string
+
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	// OIDCRedirectURL is the URL of /auth/callback on this server.
+	OIDCRedirectURL string
+	// OIDCAllowedDomains, when set, lists the email domains users must
+	// have a verified address in.
+	OIDCAllowedDomains []string
+	// SessionSecret signs session cookies. A random one is used when it is
+	// empty, so sessions end when the server restarts.
+	SessionSecret string
+}
+
+// authenticator returns the authenticator for config.Mode, or nil when no
+// authentication is configured.
+func (config authConfig) authenticator(ctx context.Context) (authenticator, error) {
+	switch config.Mode {
+	case "", "none":
+		return nil, nil
+	case "basic":
+		if config.HtpasswdFile == "" {
+			return nil, errors.New("basic authentication requires an htpasswd file")
+		}
+		auth, err := readHtpasswdFile(config.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	case "proxy":
+		if config.ProxyHeader == "" {
+			return nil, errors.New("proxy authentication requires a header")
+		}
+		return proxyHeaderAuth{header: config.ProxyHeader}, nil
+	case "oidc":
+		if config.OIDCIssuer == "" || config.OIDCClientID == "" || config.OIDCRedirectURL == "" {
+			return nil, errors.New("OIDC authentication requires an issuer, a client id, and a redirect URL")
+		}
+		sessions, err := newSessionCodec(config.SessionSecret)
+		if err != nil {
+			return nil, err
+		}
+		auth, err := newOIDCAuth(ctx, config, sessions)
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	default:
+		return nil, fmt.Errorf("unknown authentication mode %q, use basic, proxy, or oidc", config.Mode)
+	}
+}
+
+// htpasswdAuth checks HTTP basic credentials against users with bcrypt
+// password hashes, as written by "htpasswd -B".
+type htpasswdAuth struct {
+	users map[string][]byte
+	// verified caches hashes of credentials that matched, so requests after
+	// the first do not pay for bcrypt.
+	verified sync.Map
+}
+
+// dummyPasswordHash is compared against for unknown users so they take as
+// long to reject as known ones.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	return hash
+})
+
+func readHtpasswdFile(filename string) (*htpasswdAuth, error) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	auth := &htpasswdAuth{users: make(map[string][]byte)}
+	sc := bufio.NewScanner(bytes.NewReader(buf))
+	for lineNumber := 1; sc.Scan(); lineNumber++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", filename, lineNumber)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: only bcrypt password hashes are supported", filename, lineNumber)
+		}
+		auth.users[user] = []byte(hash)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(auth.users) == 0 {
+		return nil, fmt.Errorf("%s: no users", filename)
+	}
+	return auth, nil
+}
+
+func (auth *htpasswdAuth) authenticate(req *http.Request) string {
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return ""
+	}
+	hash, known := auth.users[user]
+	if !known {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return ""
+	}
+	key := sha256.Sum256(slices.Concat([]byte(user), []byte{0}, []byte(password), []byte{0}, hash))
+	if _, ok := auth.verified.Load(key); ok {
+		return user
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return ""
+	}
+	auth.verified.Store(key, struct{}{})
+	return user
+}
+
+func (auth *htpasswdAuth) challenge(res http.ResponseWriter, _ *http.Request) {
+	res.Header().Set("WWW-Authenticate", `Basic realm="Playground", charset="UTF-8"`)
+	http.Error(res, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// proxyHeaderAuth trusts the user in a header set by an authenticating
+// reverse proxy. The server must only be reachable through that proxy, since
+// anyone connecting directly could set the header.
+type proxyHeaderAuth struct {
+	header string
+}
+
+func (auth proxyHeaderAuth) authenticate(req *http.Request) string {
+	return strings.TrimSpace(req.Header.Get(auth.header))
+}
+
+func (auth proxyHeaderAuth) challenge(res http.ResponseWriter, _ *http.Request) {
+	http.Error(res, fmt.Sprintf("missing %s header, requests must come through the authenticating proxy", auth.header), http.StatusUnauthorized)
+}
+
+// oidcAuth logs users in with an OpenID Connect provider and keeps them
+// logged in with a signed session cookie. It serves the login routes under
+// /auth/.
+type oidcAuth struct {
+	config         oauth2.Config
+	verifier       *oidc.IDTokenVerifier
+	sessions       sessionCodec
+	allowedDomains []string
+	secureCookies  bool
+	now            func() time.Time
+}
+
+func newOIDCAuth(ctx context.Context, config authConfig, sessions sessionCodec) (*oidcAuth, error) {
+	provider, err := oidc.NewProvider(ctx, config.OIDCIssuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover the OIDC provider: %w", err)
+	}
+	redirectURL, err := url.Parse(config.OIDCRedirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OIDC redirect URL: %w", err)
+	}
+	allowedDomains := make([]string, 0, len(config.OIDCAllowedDomains))
+	for _, domain := range config.OIDCAllowedDomains {
+		allowedDomains = append(allowedDomains, strings.ToLower(domain))
+	}
+	return &oidcAuth{
+		config: oauth2.Config{
+			ClientID:     config.OIDCClientID,
+			ClientSecret: config.OIDCClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  config.OIDCRedirectURL,
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		verifier:       provider.Verifier(&oidc.Config{ClientID: config.OIDCClientID}),
+		sessions:       sessions,
+		allowedDomains: allowedDomains,
+		secureCookies:  redirectURL.Scheme == "https",
+		now:            time.Now,
+	}, nil
+}
+
+func (auth *oidcAuth) authenticate(req *http.Request) string {
+	cookie, err := req.Cookie(sessionCookieName)
+	if err != nil {
+		return ""
+	}
+	user, ok := auth.sessions.decode(sessionCookieName, cookie.Value, auth.now())
+	if !ok {
+		return ""
+	}
+	return user
+}
+
+// challenge sends page loads to the login route. Requests made by htmx or
+// with other methods get 401, and htmx is told to load the login page.
+func (auth *oidcAuth) challenge(res http.ResponseWriter, req *http.Request) {
+	login := "/auth/login?" + url.Values{"next": {req.URL.RequestURI()}}.Encode()
+	if req.Method == http.MethodGet && req.Header.Get("HX-Request") == "" {
+		http.Redirect(res, req, login, http.StatusFound)
+		return
+	}
+	res.Header().Set("HX-Redirect", "/auth/login")
+	http.Error(res, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func (auth *oidcAuth) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/auth/login":
+		auth.login(res, req)
+	case "/auth/callback":
+		auth.callback(res, req)
+	case "/auth/logout":
+		auth.setCookie(res, sessionCookieName, "", -1)
+		http.Redirect(res, req, "/", http.StatusFound)
+	default:
+		http.NotFound(res, req)
+	}
+}
+
+func (auth *oidcAuth) login(res http.ResponseWriter, req *http.Request) {
+	next := req.URL.Query().Get("next")
+	if !isLocalRedirect(next) {
+		next = "/"
+	}
+	state, nonce := rand.Text(), rand.Text()
+	expires := auth.now().Add(oidcLoginDuration)
+	auth.setCookie(res, oidcStateCookieName, auth.sessions.encode(oidcStateCookieName, state+" "+nonce+" "+next, expires), int(oidcLoginDuration.Seconds()))
+	http.Redirect(res, req, auth.config.AuthCodeURL(state, oidc.Nonce(nonce)), http.StatusFound)
+}
+
+func (auth *oidcAuth) callback(res http.ResponseWriter, req *http.Request) {
+	cookie, err := req.Cookie(oidcStateCookieName)
+	if err != nil {
+		http.Error(res, "login expired, try again", http.StatusBadRequest)
+		return
+	}
+	value, ok := auth.sessions.decode(oidcStateCookieName, cookie.Value, auth.now())
+	fields := strings.SplitN(value, " ", 3)
+	if !ok || len(fields) != 3 || !hmac.Equal([]byte(fields[0]), []byte(req.URL.Query().Get("state"))) {
+		http.Error(res, "login expired, try again", http.StatusBadRequest)
+		return
+	}
+	nonce, next := fields[1], fields[2]
+	auth.setCookie(res, oidcStateCookieName, "", -1)
+
+	if msg := req.URL.Query().Get("error"); msg != "" {
+		http.Error(res, "login failed: "+msg, http.StatusUnauthorized)
+		return
+	}
+	token, err := auth.config.Exchange(req.Context(), req.URL.Query().Get("code"))
+	if err != nil {
+		log.Println("failed to exchange OIDC code:", err)
+		http.Error(res, "login failed", http.StatusUnauthorized)
+		return
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	idToken, err := auth.verifier.Verify(req.Context(), rawIDToken)
+	if err != nil || idToken.Nonce != nonce {
+		log.Println("failed to verify OIDC ID token:", err)
+		http.Error(res, "login failed", http.StatusUnauthorized)
+		return
+	}
+	user, err := auth.user(idToken)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusForbidden)
+		return
+	}
+	auth.setCookie(res, sessionCookieName, auth.sessions.encode(sessionCookieName, user, auth.now().Add(sessionDuration)), int(sessionDuration.Seconds()))
+	http.Redirect(res, req, next, http.StatusFound)
+}
+
+// user returns the verified email address in idToken, or its subject when the
+// provider has no email, and checks the email against the allowed domains.
+func (auth *oidcAuth) user(idToken *oidc.IDToken) (string, error) {
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return "", err
+	}
+	if len(auth.allowedDomains) == 0 {
+		if claims.Email != "" && claims.EmailVerified {
+			return claims.Email, nil
+		}
+		return idToken.Subject, nil
+	}
+	_, domain, _ := strings.Cut(claims.Email, "@")
+	if !claims.EmailVerified || !slices.Contains(auth.allowedDomains, strings.ToLower(domain)) {
+		return "", errors.New("your account is not permitted to use this playground")
+	}
+	return claims.Email, nil
+}
+
+func (auth *oidcAuth) setCookie(res http.ResponseWriter, name, value string, maxAge int) {
+	http.SetCookie(res, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   auth.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// isLocalRedirect reports whether target is a path on this server, so the
+// login flow can not be used to redirect elsewhere.
+func isLocalRedirect(target string) bool {
+	return strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") && !strings.HasPrefix(target, "/\\")
+}
+
+// sessionCodec signs and verifies cookie values that expire. Each value is
+// signed with the name of its cookie, so a value issued for one cookie, like
+// the OIDC state anyone can get from /auth/login, is not accepted by another.
+type sessionCodec struct {
+	key []byte
+}
+
+func newSessionCodec(secret string) (sessionCodec, error) {
+	if secret == "" {
+		key := make([]byte, sha256.Size)
+		if _, err := rand.Read(key); err != nil {
+			return sessionCodec{}, err
+		}
+		log.Println("no session secret is set, sessions will end when the server restarts")
+		return sessionCodec{key: key}, nil
+	}
+	key := sha256.Sum256([]byte(secret))
+	return sessionCodec{key: key[:]}, nil
+}
+
+func (codec sessionCodec) encode(cookieName, value string, expires time.Time) string {
+	payload := []byte(strconv.FormatInt(expires.Unix(), 10) + "|" + value)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(codec.sign(cookieName, payload))
+}
+
+func (codec sessionCodec) decode(cookieName, cookie string, now time.Time) (string, bool) {
+	encodedPayload, encodedSignature, ok := strings.Cut(cookie, ".")
+	if !ok {
+		return "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, codec.sign(cookieName, payload)) {
+		return "", false
+	}
+	expiresString, value, ok := strings.Cut(string(payload), "|")
+	expires, err := strconv.ParseInt(expiresString, 10, 64)
+	if !ok || err != nil || !now.Before(time.Unix(expires, 0)) {
+		return "", false
+	}
+	return value, true
+}
+
+func (codec sessionCodec) sign(cookieName string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, codec.key)
+	mac.Write([]byte(cookieName + "\x00"))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// whoami responds with the authenticated user.
+var whoami = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+	_, _ = io.WriteString(res, userFromContext(req.Context()))
+})
+
+func Test_htpasswdAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(filename, []byte("# users\ngopher:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := authConfig{Mode: "basic", HtpasswdFile: filename}.authenticator(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := requireAuth(auth, whoami)
+
+	for _, tt := range []struct {
+		name           string
+		path           string
+		user, password string
+		want           int
+		wantBody       string
+	}{
+		{name: "anonymous", path: "/", want: http.StatusUnauthorized},
+		{name: "wrong password", path: "/", user: "gopher", password: "hunter3", want: http.StatusUnauthorized},
+		{name: "unknown user", path: "/", user: "rob", password: "hunter2", want: http.StatusUnauthorized},
+		{name: "user", path: "/", user: "gopher", password: "hunter2", want: http.StatusOK, wantBody: "gopher"},
+		{name: "cached", path: "/fmt", user: "gopher", password: "hunter2", want: http.StatusOK, wantBody: "gopher"},
+		{name: "assets", path: "/assets/main.css", want: http.StatusOK},
+		{name: "module proxy", path: "/goproxy/github.com/crhntr/dom/@v/list", want: http.StatusOK},
+		{name: "no login routes", path: "/auth/x", want: http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate challenge")
+			}
+			if tt.want == http.StatusOK && rec.Body.String() != tt.wantBody {
+				t.Errorf("got user %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+
+	if err := os.WriteFile(filename, []byte("gopher:$apr1$salt$hash\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readHtpasswdFile(filename); err == nil || !strings.Contains(err.Error(), "htpasswd:1: only bcrypt") {
+		t.Errorf("expected an error for a non bcrypt hash, got %v", err)
+	}
+}
+
+func Test_proxyHeaderAuth(t *testing.T) {
+	handler := requireAuth(proxyHeaderAuth{header: defaultAuthProxyUser}, whoami)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want 401", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/x", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected /auth/ to require authentication without login routes, got status %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(defaultAuthProxyUser, "gopher@example.com")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "gopher@example.com" {
+		t.Errorf("got status %d and user %q", rec.Code, rec.Body.String())
+	}
+}
+
+// fakeOIDCProvider issues ID tokens for email to the client with the nonce
+// of the last authorization request.
+func fakeOIDCProvider(t *testing.T, email string) *httptest.Server {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nonce string
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	writeJSON := func(res http.ResponseWriter, v any) {
+		res.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(res).Encode(v)
+	}
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(res http.ResponseWriter, req *http.Request) {
+		writeJSON(res, map[string]any{
+			"issuer":                                server.URL,
+			"authorization_endpoint":                server.URL + "/authorize",
+			"token_endpoint":                        server.URL + "/token",
+			"jwks_uri":                              server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(res http.ResponseWriter, req *http.Request) {
+		writeJSON(res, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"}}})
+	})
+	mux.HandleFunc("GET /authorize", func(res http.ResponseWriter, req *http.Request) {
+		nonce = req.URL.Query().Get("nonce")
+	})
+	mux.HandleFunc("POST /token", func(res http.ResponseWriter, req *http.Request) {
+		claims, _ := json.Marshal(map[string]any{
+			"iss":            server.URL,
+			"sub":            "1234",
+			"aud":            "playground",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          nonce,
+			"email":          email,
+			"email_verified": true,
+		})
+		signed, err := signer.Sign(claims)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		idToken, _ := signed.CompactSerialize()
+		writeJSON(res, map[string]any{"access_token": "token", "token_type": "Bearer", "id_token": idToken})
+	})
+	return server
+}
+
+func Test_oidcAuth(t *testing.T) {
+	for _, tt := range []struct {
+		email    string
+		wantUser string
+	}{
+		{email: "gopher@example.com", wantUser: "gopher@example.com"},
+		{email: "gopher@example.net"},
+	} {
+		t.Run(tt.email, func(t *testing.T) {
+			provider := fakeOIDCProvider(t, tt.email)
+			auth, err := authConfig{
+				Mode:               "oidc",
+				OIDCIssuer:         provider.URL,
+				OIDCClientID:       "playground",
+				OIDCRedirectURL:    "http://playground.example.com/auth/callback",
+				OIDCAllowedDomains: []string{"example.com"},
+				SessionSecret:      "secret",
+			}.authenticator(t.Context())
+			if err != nil {
+				t.Fatal(err)
+			}
+			mux := http.NewServeMux()
+			mux.Handle("/auth/", auth.(http.Handler))
+			mux.Handle("/", whoami)
+			handler := requireAuth(auth, mux)
+			serve := func(target string, cookies []*http.Cookie, header http.Header) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, target, nil)
+				for _, cookie := range cookies {
+					req.AddCookie(cookie)
+				}
+				for key, values := range header {
+					req.Header[key] = values
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				return rec
+			}
+
+			rec := serve("/?example=hello", nil, http.Header{"Hx-Request": {"true"}})
+			if rec.Code != http.StatusUnauthorized || rec.Header().Get("HX-Redirect") != "/auth/login" {
+				t.Fatalf("expected htmx to be sent to the login page, got status %d", rec.Code)
+			}
+			rec = serve("/?example=hello", nil, nil)
+			if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/auth/login?next=%2F%3Fexample%3Dhello" {
+				t.Fatalf("expected a redirect to login, got status %d and location %q", rec.Code, rec.Header().Get("Location"))
+			}
+			rec = serve(rec.Header().Get("Location"), nil, nil)
+			authorize, err := url.Parse(rec.Header().Get("Location"))
+			if err != nil || !strings.HasPrefix(authorize.String(), provider.URL+"/authorize") {
+				t.Fatalf("expected a redirect to the provider, got %q", authorize)
+			}
+			if res, err := http.Get(authorize.String()); err != nil {
+				t.Fatal(err)
+			} else {
+				_ = res.Body.Close()
+			}
+			stateCookies := rec.Result().Cookies()
+
+			var replayed []*http.Cookie
+			for _, cookie := range stateCookies {
+				replayed = append(replayed, &http.Cookie{Name: sessionCookieName, Value: cookie.Value})
+			}
+			if rec := serve("/", replayed, http.Header{"Hx-Request": {"true"}}); rec.Code != http.StatusUnauthorized {
+				t.Errorf("expected the state cookie to be rejected as a session, got status %d and user %q", rec.Code, rec.Body.String())
+			}
+
+			rec = serve("/auth/callback?"+url.Values{"code": {"code"}, "state": {"forged"}}.Encode(), stateCookies, nil)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("expected a forged state to be rejected, got status %d", rec.Code)
+			}
+			rec = serve("/auth/callback?"+url.Values{"code": {"code"}, "state": {authorize.Query().Get("state")}}.Encode(), stateCookies, nil)
+			if tt.wantUser == "" {
+				if rec.Code != http.StatusForbidden {
+					t.Fatalf("expected a user outside the allowed domains to be rejected, got status %d", rec.Code)
+				}
+				return
+			}
+			if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/?example=hello" {
+				t.Fatalf("got status %d and location %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+			}
+			rec = serve("/", rec.Result().Cookies(), nil)
+			if rec.Code != http.StatusOK || rec.Body.String() != tt.wantUser {
+				t.Errorf("got status %d and user %q", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func Test_sessionCodec(t *testing.T) {
+	codec, err := newSessionCodec("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cookie := codec.encode(sessionCookieName, "gopher", now.Add(time.Minute))
+	if user, ok := codec.decode(sessionCookieName, cookie, now); !ok || user != "gopher" {
+		t.Errorf("got %q, %t", user, ok)
+	}
+	if _, ok := codec.decode(sessionCookieName, cookie, now.Add(2*time.Minute)); ok {
+		t.Error("expected an expired session to be rejected")
+	}
+	other, _ := newSessionCodec("other")
+	if _, ok := other.decode(sessionCookieName, cookie, now); ok {
+		t.Error("expected a session signed with another secret to be rejected")
+	}
+	payload, signature, _ := strings.Cut(cookie, ".")
+	if _, ok := codec.decode(sessionCookieName, payload+"x."+signature, now); ok {
+		t.Error("expected a modified session to be rejected")
+	}
+	if _, ok := codec.decode(oidcStateCookieName, cookie, now); ok {
+		t.Error("expected a session to be rejected as another cookie")
+	}
+	for _, target := range []string{"//evil.example.com", "/\\evil.example.com", "https://evil.example.com"} {
+		if isLocalRedirect(target) {
+			t.Errorf("expected %q not to be a local redirect", target)
+		}
+	}
+	if !isLocalRedirect(fmt.Sprintf("/?example=%s", "hello")) {
+		t.Error("expected a path to be a local redirect")
+	}
+}
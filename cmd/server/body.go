@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxFormParts limits the parts of a multipart editor form: a name and the
+// content of each file, and the fields holding the editor state.
+const maxFormParts = 2*maxArchiveFiles + 16
+
+// bodyLimits maps route patterns, like "POST /upload", to the largest request
+// body in bytes the route accepts. It is a flag.Value set with a comma
+// separated list of "PATTERN=BYTES".
+type bodyLimits map[string]int64
+
+func defaultBodyLimits() bodyLimits {
+	return bodyLimits{
+		"POST /upload": maxUploadBytes,
+	}
+}
+
+func (limits bodyLimits) String() string {
+	entries := make([]string, 0, len(limits))
+	for pattern, limit := range limits {
+		entries = append(entries, pattern+"="+strconv.FormatInt(limit, 10))
+	}
+	slices.Sort(entries)
+	return strings.Join(entries, ",")
+}
+
+func (limits bodyLimits) Set(value string) error {
+	for entry := range strings.SplitSeq(value, ",") {
+		pattern, limitString, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(pattern) == "" {
+			return fmt.Errorf("body limit %q must have the form PATTERN=BYTES", entry)
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(limitString), 10, 64)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("body limit %q must be a positive number of bytes", entry)
+		}
+		limits[strings.TrimSpace(pattern)] = limit
+	}
+	return nil
+}
+
+func (limits bodyLimits) limit(pattern string, defaultLimit int64) int64 {
+	if limit, ok := limits[pattern]; ok {
+		return limit
+	}
+	return defaultLimit
+}
+
+// handler limits the body of each request to the limit of the route mux
+// matches it with, or defaultLimit for routes without one, and then serves it
+// with mux.
+func (limits bodyLimits) handler(mux *http.ServeMux, defaultLimit int64) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		_, pattern := mux.Handler(req)
+		req.Body = http.MaxBytesReader(res, req.Body, limits.limit(pattern, defaultLimit))
+		mux.ServeHTTP(res, req)
+	})
+}
+
+// parseRequestForm parses the form in the body of req and returns any error,
+// unlike Request.FormValue, which drops whatever does not fit in memory.
+// Multipart bodies are read a part at a time and no part may be larger than
+// maxArchiveFileBytes. A part holding a file is added to the "filename" list
+// and stored under its name, like the files of the editor form. Parsing an
+// already parsed form is a no-op.
+func parseRequestForm(req *http.Request) error {
+	if req.PostForm != nil {
+		return nil
+	}
+	mr, err := req.MultipartReader()
+	if errors.Is(err, http.ErrNotMultipart) {
+		return req.ParseForm()
+	}
+	if err != nil {
+		return err
+	}
+	form := make(url.Values)
+	for parts := 1; ; parts++ {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if parts > maxFormParts {
+			return &ArchiveError{Err: errTooManyFiles, Detail: fmt.Sprintf("more than %d form parts", maxFormParts)}
+		}
+		name := part.FormName()
+		if part.FileName() != "" {
+			name = uploadFilename(part.Header.Get("Content-Disposition"))
+			form.Add("filename", name)
+		}
+		buf, err := io.ReadAll(io.LimitReader(part, maxArchiveFileBytes+1))
+		if err != nil {
+			return err
+		}
+		if len(buf) > maxArchiveFileBytes {
+			return &ArchiveError{File: name, Err: errFileTooLarge, Detail: fmt.Sprintf("the limit is %d bytes", maxArchiveFileBytes)}
+		}
+		form.Add(name, string(buf))
+	}
+	req.PostForm = form
+	req.Form = make(url.Values)
+	for key, values := range form {
+		req.Form[key] = slices.Clone(values)
+	}
+	for key, values := range req.URL.Query() {
+		req.Form[key] = append(req.Form[key], values...)
+	}
+	req.MultipartForm = &multipart.Form{Value: form}
+	return nil
+}
+
+// writeRequestError responds to a request whose body could not be read. A
+// body or archive over a limit gets 413 Request Entity Too Large and a message
+// naming the limit; anything else is a bad request.
+func writeRequestError(res http.ResponseWriter, req *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		msg := fmt.Sprintf("request body exceeds the %d byte limit", maxBytesErr.Limit)
+		if req.Pattern != "" {
+			msg += " for " + req.Pattern
+		}
+		http.Error(res, msg, http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errTooManyFiles), errors.Is(err, errFileTooLarge), errors.Is(err, errArchiveTooLarge):
+		http.Error(res, err.Error(), http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(res, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_parseRequestForm_multipart(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("project-name", "Upload")
+	for name, content := range map[string]string{
+		"go.mod":          "module example.com\n",
+		"cmd/app/main.go": "package main\n\nfunc main() {}\n",
+	} {
+		w, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/?active-file=go.mod", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	dir, err := readMemoryDirectory(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir.Name != "Upload" || dir.ActiveFile != "go.mod" {
+		t.Errorf("got name %q and active file %q", dir.Name, dir.ActiveFile)
+	}
+	if got, want := dir.Txtar(), "-- cmd/app/main.go --\npackage main\n\nfunc main() {}\n-- go.mod --\nmodule example.com\n"; got != want {
+		t.Errorf("got archive:\n%s\nwant:\n%s", got, want)
+	}
+	if got := req.FormValue("project-name"); got != "Upload" {
+		t.Errorf("expected the parsed form to be kept, got project-name %q", got)
+	}
+}
+
+func Test_bodyLimits_handler(t *testing.T) {
+	limits := defaultBodyLimits()
+	if err := limits.Set("POST /fmt=1024"); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"POST /fmt", "POST /fmt=0", "=10"} {
+		if err := limits.Set(value); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("POST /fmt", handleFmt())
+	mux.Handle("POST /", handlePOSTIndex("go1.26", nil))
+	handler := limits.handler(mux, defaultMaxBodyBytes)
+
+	post := func(path string, size int) *httptest.ResponseRecorder {
+		form := url.Values{
+			"filename": {"main.go"},
+			"main.go":  {"package main\n\nvar _ = `" + strings.Repeat("x", size) + "`\n"},
+		}
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/fmt", 2048)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got status %d, want 413", rec.Code)
+	}
+	if got := rec.Body.String(); !strings.Contains(got, "1024 byte limit for POST /fmt") {
+		t.Errorf("expected the response to name the limit, got %q", got)
+	}
+
+	// A project larger than the old 16 KiB form limit must not be truncated.
+	rec = post("/", 64<<10)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), strings.Repeat("x", 64<<10)) {
+		t.Error("expected the whole file in the response")
+	}
+
+	rec = post("/", maxArchiveFileBytes)
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "file is too large") {
+		t.Errorf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// nativeTargets are the GOOS/GOARCH pairs handleBuild may cross-compile for.
+var nativeTargets = []string{
+	"linux/amd64",
+	"linux/arm64",
+	"darwin/amd64",
+	"darwin/arm64",
+	"windows/amd64",
+	"windows/arm64",
+}
+
+var errBuildQueueFull = errors.New("too many builds in progress, try again later")
+
+// buildQueue limits the number of go builds that run at once. Runs, web app
+// downloads and native builds share one queue.
+type buildQueue chan struct{}
+
+func newBuildQueue(size int) buildQueue { return make(buildQueue, max(size, 1)) }
+
+// acquire waits for a free slot until ctx is done and then starts charging
+// the build to the client, see startBuild. The returned function ends the
+// build and releases the slot.
+func (queue buildQueue) acquire(ctx context.Context) (func(), error) {
+	select {
+	case queue <- struct{}{}:
+	case <-ctx.Done():
+		return nil, errBuildQueueFull
+	}
+	endBuild, err := startBuild(ctx)
+	if err != nil {
+		<-queue
+		return nil, err
+	}
+	return func() {
+		endBuild()
+		<-queue
+	}, nil
+}
+
+// writeAcquireError responds to a request whose build could not start.
+func writeAcquireError(res http.ResponseWriter, err error) {
+	var quota *buildQuotaError
+	if errors.As(err, &quota) {
+		writeTooManyRequests(res, quota.retryAfter, quota.Error())
+		return
+	}
+	http.Error(res, err.Error(), http.StatusServiceUnavailable)
+}
+
+// handleBuild cross-compiles the project for the GOOS/GOARCH in the "target"
+// form value and responds with the executable as a download.
+func handleBuild(goExecPath string, goEnv goEnvironment, queue buildQueue, timeout time.Duration) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if err := parseRequestForm(req); err != nil {
+			writeRequestError(res, req, err)
+			return
+		}
+		target := req.FormValue("target")
+		if !slices.Contains(nativeTargets, target) {
+			http.Error(res, "unsupported build target", http.StatusBadRequest)
+			return
+		}
+		goos, goarch, _ := strings.Cut(target, "/")
+
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+
+		dir, err := newRequestDirectory(req)
+		if err != nil {
+			writeRequestError(res, req, err)
+			return
+		}
+		defer func() {
+			_ = dir.close()
+		}()
+
+		release, err := queue.acquire(ctx)
+		if err != nil {
+			writeAcquireError(res, err)
+			return
+		}
+		defer release()
+
+		filename := downloadFilename(dir.Name)
+		if goos == "windows" {
+			filename += ".exe"
+		}
+		platform := []string{"GOOS=" + goos, "GOARCH=" + goarch}
+		executable, err := dir.build(ctx, goEnv, platform, goExecPath, filename, "-trimpath")
+		if err != nil {
+			auditOutcome(req.Context(), "build failed")
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		res.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		res.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(res, req, filename, archiveModTime, bytes.NewReader(executable))
+	}
+}
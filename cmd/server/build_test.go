@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_buildQueue(t *testing.T) {
+	queue := newBuildQueue(1)
+	release, err := queue.acquire(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := queue.acquire(ctx); !errors.Is(err, errBuildQueueFull) {
+		t.Fatalf("expected errBuildQueueFull, got %v", err)
+	}
+
+	release()
+	release, err = queue.acquire(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func Test_handleBuild(t *testing.T) {
+	goExecPath, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not found")
+	}
+	handler := handleBuild(goExecPath, goEnvironment{}, newBuildQueue(1), defaultBuildTimeout)
+
+	newRequest := func(target string) *http.Request {
+		form := url.Values{
+			"project-name": {"Tool"},
+			"target":       {target},
+			"filename":     {"go.mod", "main.go"},
+			"go.mod":       {"module example.com\n\ngo 1.25\n"},
+			"main.go":      {"package main\n\nfunc main() {}\n"},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/go/build", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest("plan9/386"))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected unsupported target to fail, got status %d", rec.Code)
+	}
+
+	if testing.Short() {
+		t.Skip("skipping build in short mode")
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest("windows/amd64"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got, want := rec.Header().Get("Content-Disposition"), "attachment; filename=tool.exe"; got != want {
+		t.Errorf("got Content-Disposition %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(rec.Body.String(), "MZ") {
+		t.Error("expected a windows executable")
+	}
+}
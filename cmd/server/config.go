@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPort          = "8080"
+	defaultBuildTimeout  = 30 * time.Second
+	defaultTidyTimeout   = time.Minute
+	defaultImportTimeout = 15 * time.Second
+	// defaultMaxBodyBytes is the default request body limit. The editor form
+	// holds the whole archive, URL encoded, so it allows for maxArchiveBytes.
+	defaultMaxBodyBytes   = 1 << 23
+	defaultMaxHeaderBytes = 1 << 13
+	// minMaxHeaderBytes leaves room for the request line of the longest
+	// share link and the other headers of the request.
+	minMaxHeaderBytes = maxShareEncodedBytes + 1<<10
+)
+
+// Config holds the settings of the server. loadConfig reads each setting from,
+// in increasing order of precedence, its default, the config file, its
+// environment variable, and its flag.
+type Config struct {
+	Port string
+
+	// BuildTimeout limits running, building, and downloading a web app,
+	// TidyTimeout limits go mod tidy, and ImportTimeout limits loading a
+	// project from another service.
+	BuildTimeout, TidyTimeout, ImportTimeout time.Duration
+
+	// MaxBodyBytes limits request bodies on routes without a BodyLimits entry.
+	MaxBodyBytes   int64
+	MaxHeaderBytes int
+	BodyLimits     bodyLimits
+
+	RateLimits         rateBudgets
+	BuildQuota         time.Duration
+	TrustedProxyHeader string
+	// GistRateLimit is shared by all clients importing from GitHub and the
+	// module proxy, to stay within their rate limits.
+	GistRateLimit rateBudget
+	GitHub        gitHubConfig
+	// GoProxy is the GOPROXY list /mod/ imports download from when there is
+	// no ModuleCache.
+	GoProxy string
+
+	// Examples is a directory of txtar examples to use instead of the
+	// embedded ones.
+	Examples string
+
+	Policy      policyFiles
+	ModuleCache string
+	Sandbox     sandbox
+
+	// Auth reads its secrets from OIDC_CLIENT_SECRET and SESSION_SECRET
+	// only, so they are never in a config file or printed.
+	Auth authConfig
+
+	AuditLog          string
+	AuditRetention    time.Duration
+	AuditKeepArchives bool
+
+	ServeLibraries bool
+	// ScriptSources and StyleSources default to defaultLibrarySource unless
+	// ServeLibraries is set.
+	ScriptSources, StyleSources stringList
+}
+
+func defaultConfig() Config {
+	return Config{
+		Port:           defaultPort,
+		BuildTimeout:   defaultBuildTimeout,
+		TidyTimeout:    defaultTidyTimeout,
+		ImportTimeout:  defaultImportTimeout,
+		MaxBodyBytes:   defaultMaxBodyBytes,
+		MaxHeaderBytes: defaultMaxHeaderBytes,
+		BodyLimits:     defaultBodyLimits(),
+		RateLimits:     defaultRateBudgets(),
+		BuildQuota:     defaultBuildQuota,
+		GistRateLimit:  defaultGistRateLimit,
+		Auth:           authConfig{ProxyHeader: defaultAuthProxyUser},
+		AuditRetention: defaultAuditRetention,
+		ServeLibraries: serveLibrariesByDefault,
+	}
+}
+
+// commands are flags that make the server do something else than serve.
+type commands struct {
+	ConfigFile        string
+	PrintConfig       bool
+	SeedModuleCache   bool
+	DownloadLibraries string
+}
+
+// configFlags defines the flags of the settings of a Config and records the
+// environment variable of each.
+type configFlags struct {
+	*flag.FlagSet
+	env map[string]string
+}
+
+func (f configFlags) usage(name, env, usage string) string {
+	f.env[name] = env
+	return usage + " [$" + env + "]"
+}
+
+func (f configFlags) string(p *string, name, env, usage string) {
+	f.StringVar(p, name, *p, f.usage(name, env, usage))
+}
+
+func (f configFlags) bool(p *bool, name, env, usage string) {
+	f.BoolVar(p, name, *p, f.usage(name, env, usage))
+}
+
+func (f configFlags) int(p *int, name, env, usage string) {
+	f.IntVar(p, name, *p, f.usage(name, env, usage))
+}
+
+func (f configFlags) int64(p *int64, name, env, usage string) {
+	f.Int64Var(p, name, *p, f.usage(name, env, usage))
+}
+
+func (f configFlags) uint64(p *uint64, name, env, usage string) {
+	f.Uint64Var(p, name, *p, f.usage(name, env, usage))
+}
+
+func (f configFlags) duration(p *time.Duration, name, env, usage string) {
+	f.DurationVar(p, name, *p, f.usage(name, env, usage))
+}
+
+func (f configFlags) value(v flag.Value, name, env, usage string) {
+	f.Var(v, name, f.usage(name, env, usage))
+}
+
+// newConfigFlags returns the flags setting cfg and cmd.
+func newConfigFlags(name string, cfg *Config, cmd *commands) configFlags {
+	f := configFlags{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError), env: make(map[string]string)}
+	f.StringVar(&cmd.ConfigFile, "config", "", "file of \"name = value\" lines setting flags, like the output of -print-config [$CONFIG_FILE]")
+	f.BoolVar(&cmd.PrintConfig, "print-config", false, "print the configuration and exit")
+	f.BoolVar(&cmd.SeedModuleCache, "seed-module-cache", false, "download the permitted modules into the module cache and exit")
+	f.StringVar(&cmd.DownloadLibraries, "download-libraries", "", "download the pinned htmx and CodeMirror files into the directory and exit")
+
+	f.string(&cfg.Port, "port", "PORT", "port to listen on")
+	f.duration(&cfg.BuildTimeout, "build-timeout", "BUILD_TIMEOUT", "time limit of running, building, and downloading a web app")
+	f.duration(&cfg.TidyTimeout, "tidy-timeout", "TIDY_TIMEOUT", "time limit of go mod tidy")
+	f.duration(&cfg.ImportTimeout, "import-timeout", "IMPORT_TIMEOUT", "time limit of importing a project")
+	f.int64(&cfg.MaxBodyBytes, "max-body-bytes", "MAX_BODY_BYTES", "largest request body in bytes for routes without a -body-limit")
+	f.int(&cfg.MaxHeaderBytes, "max-header-bytes", "MAX_HEADER_BYTES", "largest request header in bytes")
+	f.value(cfg.BodyLimits, "body-limit", "BODY_LIMITS", "largest request body in bytes for a route, as PATTERN=BYTES like \"POST /upload=4194304\"; may be repeated or comma separated")
+
+	f.value(cfg.RateLimits, "rate-limit", "RATE_LIMITS", "requests each client may make to a route class (build, edit, or import), as CLASS=N/DURATION like \"build=30/1m\"; may be repeated or comma separated")
+	f.duration(&cfg.BuildQuota, "build-quota", "BUILD_QUOTA", "time each client may spend building each day; 0 disables the quota")
+	f.string(&cfg.TrustedProxyHeader, "trusted-proxy-header", "TRUSTED_PROXY_HEADER", "header, like X-Forwarded-For, holding the client address set by a trusted proxy")
+	f.value(&cfg.GistRateLimit, "gist-rate-limit", "GIST_RATE_LIMIT", "requests all clients may make to the GitHub API, as N/DURATION")
+	f.string(&cfg.GitHub.BaseURL, "github-base-url", "GITHUB_BASE_URL", "API URL of a GitHub Enterprise instance to import gists and repositories from; the token is read from GITHUB_ENTERPRISE_TOKEN")
+	f.string(&cfg.GitHub.UploadURL, "github-upload-url", "GITHUB_UPLOAD_URL", "upload URL of the GitHub Enterprise instance; defaults to -github-base-url")
+	f.string(&cfg.GoProxy, "goproxy", "GOPROXY", "module proxy list /mod/ imports download from without a -module-cache; defaults to "+defaultGoProxy+", and a list without a proxy URL, like off, disables /mod/ imports")
+	f.string(&cfg.Examples, "examples", "EXAMPLES_DIR", "directory of txtar examples to use instead of the embedded ones")
+
+	f.string(&cfg.Policy.Dir, "policy-dir", "POLICY_DIR", "directory holding "+packagePolicyFile+", "+modulePolicyFile+", and "+sourcePolicyFile)
+	f.string(&cfg.Policy.Packages, "import-allow-list", "IMPORT_ALLOW_LIST", "file listing the standard library packages projects may import")
+	f.string(&cfg.Policy.Modules, "module-allow-list", "MODULE_ALLOW_LIST", "file listing the modules and versions projects may require")
+	f.string(&cfg.Policy.Source, "source-policy", "SOURCE_POLICY", "file listing the source rules projects may not break")
+	f.string(&cfg.ModuleCache, "module-cache", "MODULE_CACHE", "module cache, laid out like GOMODCACHE, to serve at /goproxy/ and build with instead of the host's GOPROXY")
+
+	f.int(&cfg.Sandbox.UID, "sandbox-uid", "SANDBOX_UID", "unprivileged user id go subprocesses run as; requires running as root")
+	f.int(&cfg.Sandbox.GID, "sandbox-gid", "SANDBOX_GID", "group id go subprocesses run as; defaults to the sandbox user id")
+	f.duration(&cfg.Sandbox.CPU, "sandbox-cpu", "SANDBOX_CPU", "CPU time limit of each go subprocess")
+	f.uint64(&cfg.Sandbox.MemoryBytes, "sandbox-memory", "SANDBOX_MEMORY", "memory limit in bytes of each go subprocess")
+	f.uint64(&cfg.Sandbox.Processes, "sandbox-processes", "SANDBOX_PROCESSES", "limit on the processes the sandbox user may run; requires -sandbox-uid")
+	f.uint64(&cfg.Sandbox.FileSizeBytes, "sandbox-file-size", "SANDBOX_FILE_SIZE", "limit in bytes on the size of each file a go subprocess writes")
+	f.int64(&cfg.Sandbox.DiskQuotaBytes, "sandbox-disk-quota", "SANDBOX_DISK_QUOTA", "limit in bytes on the files a request may write, including build caches")
+	f.bool(&cfg.Sandbox.IsolateNetwork, "sandbox-isolate-network", "SANDBOX_ISOLATE_NETWORK", "run go subprocesses without network access; modules then come from -module-cache")
+
+	f.string(&cfg.Auth.Mode, "auth", "AUTH", "authentication: basic, proxy, oidc, or empty for none")
+	f.string(&cfg.Auth.HtpasswdFile, "htpasswd", "HTPASSWD_FILE", "htpasswd file with bcrypt hashes for basic authentication")
+	f.string(&cfg.Auth.ProxyHeader, "auth-proxy-header", "AUTH_PROXY_HEADER", "header holding the user set by the authenticating proxy")
+	f.string(&cfg.Auth.OIDCIssuer, "oidc-issuer", "OIDC_ISSUER", "OpenID Connect issuer URL")
+	f.string(&cfg.Auth.OIDCClientID, "oidc-client-id", "OIDC_CLIENT_ID", "OpenID Connect client id; the secret is read from OIDC_CLIENT_SECRET")
+	f.string(&cfg.Auth.OIDCRedirectURL, "oidc-redirect-url", "OIDC_REDIRECT_URL", "URL of /auth/callback on this server")
+	f.value((*stringList)(&cfg.Auth.OIDCAllowedDomains), "oidc-allowed-domains", "OIDC_ALLOWED_DOMAINS", "comma separated email domains OpenID Connect users must belong to")
+
+	f.string(&cfg.AuditLog, "audit-log", "AUDIT_LOG_DIR", "directory to write the JSON lines audit log of runs, builds, tidies, formats, uploads, and imports to")
+	f.duration(&cfg.AuditRetention, "audit-retention", "AUDIT_RETENTION", "how long audit log files and stored archives are kept; 0 keeps them forever")
+	f.bool(&cfg.AuditKeepArchives, "audit-keep-archives", "AUDIT_KEEP_ARCHIVES", "store each audited project in the archives directory of the audit log")
+
+	f.bool(&cfg.ServeLibraries, "serve-libraries", "SERVE_LIBRARIES", "serve htmx and CodeMirror from the libraries embedded with the selfhosted build tag instead of cdnjs")
+	f.value(&cfg.ScriptSources, "csp-script-src", "CSP_SCRIPT_SRC", "comma separated Content-Security-Policy sources, besides the server, the editor may load scripts from; defaults to "+defaultLibrarySource+" unless -serve-libraries is set")
+	f.value(&cfg.StyleSources, "csp-style-src", "CSP_STYLE_SRC", "comma separated Content-Security-Policy sources, besides the server, the editor may load styles from; defaults to "+defaultLibrarySource+" unless -serve-libraries is set")
+	return f
+}
+
+// loadConfig returns the configuration set by args, the config file, and the
+// environment, and the commands set by args.
+func loadConfig(name string, args []string, lookupEnv func(string) (string, bool)) (Config, commands, error) {
+	// The first pass finds the config file and checks the arguments. The
+	// second sets the config file and environment before the flags, which
+	// take precedence.
+	var cmd commands
+	first := defaultConfig()
+	if err := newConfigFlags(name, &first, &cmd).Parse(args); err != nil {
+		return Config{}, cmd, err
+	}
+	if cmd.ConfigFile == "" {
+		cmd.ConfigFile, _ = lookupEnv("CONFIG_FILE")
+	}
+
+	cfg := defaultConfig()
+	var ignored commands
+	f := newConfigFlags(name, &cfg, &ignored)
+	f.SetOutput(io.Discard)
+	if cmd.ConfigFile != "" {
+		if err := readConfigFile(f, cmd.ConfigFile); err != nil {
+			return Config{}, cmd, err
+		}
+	}
+	var errs []error
+	f.VisitAll(func(fl *flag.Flag) {
+		env, ok := f.env[fl.Name]
+		if !ok {
+			return
+		}
+		if v, ok := lookupEnv(env); ok && v != "" {
+			if err := fl.Value.Set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", env, err))
+			}
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, cmd, err
+	}
+	if err := f.Parse(args); err != nil {
+		return Config{}, cmd, err
+	}
+	if v, ok := lookupEnv("OIDC_CLIENT_SECRET"); ok {
+		cfg.Auth.OIDCClientSecret = v
+	}
+	if v, ok := lookupEnv("SESSION_SECRET"); ok {
+		cfg.Auth.SessionSecret = v
+	}
+	if v, ok := lookupEnv("GITHUB_TOKEN"); ok {
+		cfg.GitHub.Token = v
+	}
+	if v, ok := lookupEnv("GITHUB_ENTERPRISE_TOKEN"); ok {
+		cfg.GitHub.EnterpriseToken = v
+	}
+	if !cfg.ServeLibraries {
+		if len(cfg.ScriptSources) == 0 {
+			cfg.ScriptSources = stringList{defaultLibrarySource}
+		}
+		if len(cfg.StyleSources) == 0 {
+			cfg.StyleSources = stringList{defaultLibrarySource}
+		}
+	}
+	return cfg, cmd, cfg.validate()
+}
+
+// readConfigFile sets the flags named in filename. Each line has the form
+// "name = value"; blank lines and lines starting with # are ignored.
+func readConfigFile(f configFlags, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer closeAndIgnoreError(file)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok {
+			return fmt.Errorf("%s:%d: expected name = value", filename, n)
+		}
+		if _, isSetting := f.env[name]; !isSetting {
+			return fmt.Errorf("%s:%d: unknown setting %q", filename, n, name)
+		}
+		if err := f.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: %w", filename, n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (cfg Config) validate() error {
+	var errs []error
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 0 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port %q must be a number from 0 to 65535", cfg.Port))
+	}
+	for name, d := range map[string]time.Duration{
+		"build timeout":  cfg.BuildTimeout,
+		"tidy timeout":   cfg.TidyTimeout,
+		"import timeout": cfg.ImportTimeout,
+	} {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", name))
+		}
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		errs = append(errs, errors.New("max body bytes must be positive"))
+	}
+	if cfg.MaxHeaderBytes < minMaxHeaderBytes {
+		errs = append(errs, fmt.Errorf("max header bytes must be at least %d to fit share links", minMaxHeaderBytes))
+	}
+	if cfg.BuildQuota < 0 {
+		errs = append(errs, errors.New("build quota must not be negative"))
+	}
+	if cfg.AuditRetention < 0 {
+		errs = append(errs, errors.New("audit retention must not be negative"))
+	}
+	if cfg.Sandbox.IsolateNetwork && cfg.ModuleCache == "" {
+		errs = append(errs, errors.New("network isolation requires a module cache"))
+	}
+	if cfg.Examples != "" {
+		if matches, err := fs.Glob(os.DirFS(cfg.Examples), "*.txtar"); err != nil || len(matches) == 0 {
+			errs = append(errs, fmt.Errorf("examples directory %s has no .txtar files", cfg.Examples))
+		}
+	}
+	for _, sources := range []stringList{cfg.ScriptSources, cfg.StyleSources} {
+		if err := checkSources(sources); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// print writes the settings of cfg in the config file format. Secrets are
+// left out.
+func (cfg Config) print(w io.Writer) error {
+	var cmd commands
+	f := newConfigFlags("", &cfg, &cmd)
+	var err error
+	f.VisitAll(func(fl *flag.Flag) {
+		if _, isSetting := f.env[fl.Name]; isSetting && err == nil {
+			_, err = fmt.Fprintf(w, "%s = %s\n", fl.Name, fl.Value)
+		}
+	})
+	return err
+}
+
+// examplesDir returns the directory of txtar examples.
+func (cfg Config) examplesDir() (fs.FS, error) {
+	if cfg.Examples != "" {
+		dir, err := filepath.Abs(cfg.Examples)
+		if err != nil {
+			return nil, err
+		}
+		return os.DirFS(dir), nil
+	}
+	return fs.Sub(assets, "assets/examples")
+}
+
+// stringList is a flag.Value set with a comma separated list.
+type stringList []string
+
+func (list stringList) String() string { return strings.Join(list, ",") }
+
+func (list *stringList) Set(value string) error {
+	*list = nil
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*list = append(*list, item)
+		}
+	}
+	return nil
+}
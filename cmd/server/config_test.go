@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mapEnv returns a lookupEnv function for env.
+func mapEnv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func Test_loadConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "playground.conf")
+	if err := os.WriteFile(filename, []byte(`# deployment settings
+port = 9000
+build-timeout = 45s
+rate-limit = edit=10/1m
+oidc-allowed-domains = example.com, example.net
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"CONFIG_FILE":    filename,
+		"PORT":           "9001",
+		"RATE_LIMITS":    "build=5/10s",
+		"SESSION_SECRET": "secret",
+		"GITHUB_TOKEN":   "secret",
+		"GOPROXY":        "https://proxy.example.com,direct",
+	}
+
+	cfg, cmd, err := loadConfig("server", nil, mapEnv(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.ConfigFile != filename {
+		t.Errorf("expected the config file from the environment, got %q", cmd.ConfigFile)
+	}
+	if cfg.Port != "9001" {
+		t.Errorf("expected the environment to override the config file, got port %q", cfg.Port)
+	}
+	if cfg.BuildTimeout != 45*time.Second || cfg.TidyTimeout != defaultTidyTimeout {
+		t.Errorf("got build timeout %s and tidy timeout %s", cfg.BuildTimeout, cfg.TidyTimeout)
+	}
+	if got, want := cfg.RateLimits.String(), "build=5/10s,edit=10/1m0s,import=30/1m0s"; got != want {
+		t.Errorf("got rate limits %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(cfg.Auth.OIDCAllowedDomains, []string{"example.com", "example.net"}) || cfg.Auth.SessionSecret != "secret" {
+		t.Errorf("unexpected auth config %+v", cfg.Auth)
+	}
+	if cfg.GitHub.Token != "secret" || cfg.GoProxy != "https://proxy.example.com,direct" {
+		t.Errorf("unexpected GitHub token or GOPROXY %+v %q", cfg.GitHub, cfg.GoProxy)
+	}
+	if cfg.ServeLibraries || !reflect.DeepEqual(cfg.ScriptSources, stringList{defaultLibrarySource}) {
+		t.Errorf("expected libraries from %s, got %q", defaultLibrarySource, cfg.ScriptSources)
+	}
+
+	cfg, cmd, err = loadConfig("server", []string{"-port", "9002", "-print-config"}, mapEnv(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != "9002" || !cmd.PrintConfig {
+		t.Errorf("expected flags to override the environment, got port %q", cfg.Port)
+	}
+
+	var printed bytes.Buffer
+	if err := cfg.print(&printed); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(printed.String(), "secret") {
+		t.Errorf("expected secrets to be left out of the printed config:\n%s", printed.String())
+	}
+	if !strings.Contains(printed.String(), "port = 9002\n") {
+		t.Errorf("expected the port in the printed config:\n%s", printed.String())
+	}
+	if err := os.WriteFile(filename, printed.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, _, err := loadConfig("server", []string{"-config", filename}, mapEnv(map[string]string{"SESSION_SECRET": "secret", "GITHUB_TOKEN": "secret"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reloaded, cfg) {
+		t.Errorf("expected the printed config to load the same config\ngot  %+v\nwant %+v", reloaded, cfg)
+	}
+}
+
+func Test_loadConfig_invalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "playground.conf")
+	if err := os.WriteFile(filename, []byte("port = 8080\nlisten = :8080\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "unknown setting", args: []string{"-config", filename}, wantErr: "playground.conf:2: unknown setting \"listen\""},
+		{name: "port", args: []string{"-port", "http"}, wantErr: "port \"http\" must be a number"},
+		{name: "timeout", env: map[string]string{"TIDY_TIMEOUT": "0s"}, wantErr: "tidy timeout must be positive"},
+		{name: "environment", env: map[string]string{"MAX_BODY_BYTES": "lots"}, wantErr: "MAX_BODY_BYTES"},
+		{name: "max header bytes", args: []string{"-max-header-bytes", "4096"}, wantErr: "max header bytes must be at least"},
+		{name: "gist rate limit", args: []string{"-gist-rate-limit", "5"}, wantErr: "must have the form N/DURATION"},
+		{name: "network isolation", args: []string{"-sandbox-isolate-network"}, wantErr: "network isolation requires a module cache"},
+		{name: "examples", args: []string{"-examples", t.TempDir()}, wantErr: "has no .txtar files"},
+		{name: "csp source", args: []string{"-csp-script-src", "'unsafe-inline'"}, wantErr: "invalid Content-Security-Policy source"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := loadConfig("server", tt.args, mapEnv(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 
 	"golang.org/x/tools/txtar"
@@ -19,6 +18,9 @@ import (
 type FilesystemDirectory struct {
 	MemoryDirectory
 	TempDir string
+	// HomeDir holds the caches and temporary files of go subprocesses run
+	// for the project. See goEnvironment.env.
+	HomeDir string
 	Modules []Module
 
 	Output bytes.Buffer
@@ -48,17 +50,19 @@ func (dir *FilesystemDirectory) checkDependencies() error {
 	if err != nil {
 		return err
 	}
-	dir.Modules = append(dir.Modules, mods...)
-	return err
+	dir.Modules = mods
+	return nil
 }
 
 func (dir *FilesystemDirectory) close() error {
-	if dir.TempDir == "" {
-		return nil
-	}
-	if err := os.RemoveAll(dir.TempDir); err != nil {
-		slog.Error("failed to remove temporary directory", "dir", dir.TempDir)
-		return fmt.Errorf("failed to delete temporary directory")
+	for _, tmp := range []string{dir.TempDir, dir.HomeDir} {
+		if tmp == "" {
+			continue
+		}
+		if err := os.RemoveAll(tmp); err != nil {
+			slog.Error("failed to remove temporary directory", "dir", tmp)
+			return fmt.Errorf("failed to delete temporary directory")
+		}
 	}
 	return nil
 }
@@ -82,24 +86,51 @@ func (dir *FilesystemDirectory) writeFiles() error {
 		return fmt.Errorf("failed to create temporary directory")
 	}
 	dir.TempDir = tmp
+	home, err := os.MkdirTemp("", "home-")
+	if err != nil {
+		log.Println("failed to create temporary directory", err)
+		return errors.Join(fmt.Errorf("failed to create temporary directory"), dir.close())
+	}
+	dir.HomeDir = home
+	if err := os.Mkdir(filepath.Join(home, "tmp"), 0o700); err != nil {
+		return errors.Join(err, dir.close())
+	}
 	dirFS, err := txtar.FS(dir.Archive)
 	if err != nil {
 		return errors.Join(err, dir.close())
 	}
 	if err := os.CopyFS(tmp, dirFS); err != nil {
-		_ = os.RemoveAll(tmp)
-		return err
+		return errors.Join(err, dir.close())
 	}
 	return nil
 }
 
-func (dir *FilesystemDirectory) execGo(ctx context.Context, env []string, goExecPath string, args ...string) error {
-	cmd := exec.CommandContext(ctx, goExecPath, args...)
-	cmd.Stdout = io.MultiWriter(os.Stdout, &dir.Output)
+// execGo runs the go command in the project directory with the environment
+// and sandbox of goEnv and the variables in override set. Its output is
+// mirrored to stdout and kept in dir.Output.
+func (dir *FilesystemDirectory) execGo(ctx context.Context, goEnv goEnvironment, override []string, goExecPath string, args ...string) error {
+	return dir.runGo(ctx, goEnv, override, io.MultiWriter(os.Stdout, &dir.Output), goExecPath, args...)
+}
+
+// runGo is like execGo but writes standard output to stdout. When the sandbox
+// stops the command, the reason is added to dir.Output and returned.
+func (dir *FilesystemDirectory) runGo(ctx context.Context, goEnv goEnvironment, override []string, stdout io.Writer, goExecPath string, args ...string) error {
+	if err := goEnv.sandbox.prepare(dir.TempDir, dir.HomeDir); err != nil {
+		return err
+	}
+	cmd, err := goEnv.sandbox.command(ctx, goExecPath, args...)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = stdout
 	cmd.Stderr = io.MultiWriter(os.Stdout, &dir.Output)
-	cmd.Env = env
+	cmd.Env = goEnv.env(dir.HomeDir, override...)
 	cmd.Dir = dir.TempDir
-	if err := cmd.Run(); err != nil {
+	if err := goEnv.sandbox.run(cmd, dir.TempDir, dir.HomeDir); err != nil {
+		var violation *sandboxViolation
+		if errors.As(err, &violation) {
+			_, _ = fmt.Fprintln(&dir.Output, violation)
+		}
 		return err
 	}
 	return nil
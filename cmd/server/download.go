@@ -0,0 +1,181 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/txtar"
+)
+
+// archiveModTime is the modification time set on every downloaded archive
+// entry so repeated downloads of the same project are byte-identical. It is
+// the earliest time a zip file can represent.
+var archiveModTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type downloadFormat struct {
+	extension   string
+	contentType string
+	write       func(w io.Writer, archive *txtar.Archive) error
+}
+
+var downloadFormats = map[string]downloadFormat{
+	"zip":    {extension: ".zip", contentType: "application/zip", write: writeZipArchive},
+	"txtar":  {extension: ".txtar", contentType: "text/plain; charset=utf-8", write: writeTxtarArchive},
+	"tar.gz": {extension: ".tar.gz", contentType: "application/gzip", write: writeTarGzArchive},
+	"json":   {extension: ".json", contentType: "application/json; charset=utf-8", write: writeJSONArchive},
+	"gist":   {extension: ".gist.json", contentType: "application/json; charset=utf-8", write: writeGistArchive},
+}
+
+func handleDownload(res http.ResponseWriter, req *http.Request) {
+	dir, err := readMemoryDirectory(req)
+	if err != nil {
+		writeRequestError(res, req, err)
+		return
+	}
+	dir.ServeHTTP(res, req)
+}
+
+// negotiateDownloadFormat returns the key in downloadFormats chosen by the
+// "format" parameter or, when that is not set, the media type in the Accept
+// header with the highest q-value. Media types with q=0 are never chosen. It
+// defaults to "zip".
+func negotiateDownloadFormat(req *http.Request) string {
+	if format := req.FormValue("format"); format != "" {
+		if _, ok := downloadFormats[format]; ok {
+			return format
+		}
+	}
+	format, bestQ := "zip", 0.0
+	for accepted := range strings.SplitSeq(req.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		var candidate string
+		switch mediaType {
+		case "application/zip":
+			candidate = "zip"
+		case "application/gzip", "application/x-gzip", "application/x-tar", "application/x-gtar":
+			candidate = "tar.gz"
+		case "application/json":
+			candidate = "json"
+		case "text/plain":
+			candidate = "txtar"
+		}
+		if candidate != "" && q > bestQ {
+			format, bestQ = candidate, q
+		}
+	}
+	return format
+}
+
+// downloadFilename derives a file name, without extension, from a project
+// name by keeping letters, digits, dots, and dashes.
+func downloadFilename(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		switch {
+		case 'a' <= r && r <= 'z', '0' <= r && r <= '9', r == '.', r == '-', r == '_':
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('-')
+		}
+	}
+	filename := strings.Trim(sb.String(), ".-")
+	for strings.Contains(filename, "--") {
+		filename = strings.ReplaceAll(filename, "--", "-")
+	}
+	if filename == "" {
+		return "playground"
+	}
+	return filename
+}
+
+func sortedFiles(archive *txtar.Archive) []txtar.File {
+	files := slices.Clone(archive.Files)
+	slices.SortFunc(files, func(a, b txtar.File) int { return strings.Compare(a.Name, b.Name) })
+	return files
+}
+
+func writeZipArchive(w io.Writer, archive *txtar.Archive) error {
+	zw := zip.NewWriter(w)
+	for _, file := range sortedFiles(archive) {
+		header := &zip.FileHeader{Name: file.Name, Method: zip.Deflate, Modified: archiveModTime}
+		header.SetMode(0o644)
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(file.Data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeTxtarArchive(w io.Writer, archive *txtar.Archive) error {
+	_, err := w.Write(txtar.Format(&txtar.Archive{Comment: archive.Comment, Files: sortedFiles(archive)}))
+	return err
+}
+
+func writeTarGzArchive(w io.Writer, archive *txtar.Archive) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, file := range sortedFiles(archive) {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     file.Name,
+			Mode:     0o644,
+			Size:     int64(len(file.Data)),
+			ModTime:  archiveModTime,
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(file.Data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeJSONArchive(w io.Writer, archive *txtar.Archive) error {
+	files := make(map[string]string, len(archive.Files))
+	for _, file := range archive.Files {
+		files[file.Name] = string(file.Data)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(files)
+}
+
+// writeGistArchive writes the body of a GitHub API request creating a gist of
+// the archive, for users to post with their own token, like
+// "gh api gists --input project.gist.json". The gist is secret unless they
+// add "public": true, which gistSource needs to import it.
+func writeGistArchive(w io.Writer, archive *txtar.Archive) error {
+	gist, err := memoryDirectoryToGist(MemoryDirectory{Archive: archive}, "Go Playground")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(gist)
+}
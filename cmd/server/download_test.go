@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+func Test_negotiateDownloadFormat(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		query  string
+		accept string
+		want   string
+	}{
+		{name: "default", want: "zip"},
+		{name: "query", query: "format=tar.gz", want: "tar.gz"},
+		{name: "unknown query falls back to accept", query: "format=rar", accept: "application/json", want: "json"},
+		{name: "accept txtar", accept: "text/plain; charset=utf-8", want: "txtar"},
+		{name: "accept list", accept: "text/html, application/gzip;q=0.9", want: "tar.gz"},
+		{name: "query wins", query: "format=json", accept: "application/zip", want: "json"},
+		{name: "refused", accept: "application/zip;q=0, application/json", want: "json"},
+		{name: "highest q-value", accept: "application/json;q=0.5, text/plain;q=0.8", want: "txtar"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/download?"+tt.query, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			if got := negotiateDownloadFormat(req); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_downloadFilename(t *testing.T) {
+	for name, want := range map[string]string{
+		"":                      "playground",
+		"hello-world":           "hello-world",
+		"My Gist: Demo!":        "my-gist-demo",
+		"github.com/a/b@v1/cmd": "github.com-a-b-v1-cmd",
+		"../../etc/passwd":      "etc-passwd",
+		"  Upload  ":            "upload",
+		"ünicøde name":          "nic-de-name",
+	} {
+		if got := downloadFilename(name); got != want {
+			t.Errorf("downloadFilename(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func Test_downloadFormats_deterministic(t *testing.T) {
+	archive := &txtar.Archive{Files: []txtar.File{
+		{Name: "main.go", Data: []byte("package main\n")},
+		{Name: "go.mod", Data: []byte("module example.com\n")},
+	}}
+	for name, format := range downloadFormats {
+		t.Run(name, func(t *testing.T) {
+			var first, second bytes.Buffer
+			if err := format.write(&first, archive); err != nil {
+				t.Fatal(err)
+			}
+			if err := format.write(&second, archive); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(first.Bytes(), second.Bytes()) {
+				t.Error("repeated downloads differ")
+			}
+		})
+	}
+}
+
+func Test_handleDownload(t *testing.T) {
+	form := url.Values{
+		"project-name": {"Hello World"},
+		"filename":     {"main.go"},
+		"main.go":      {"package main\n"},
+		"format":       {"txtar"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/download", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handleDownload(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got, want := rec.Header().Get("Content-Disposition"), `attachment; filename=hello-world.txtar`; got != want {
+		t.Errorf("got Content-Disposition %q, want %q", got, want)
+	}
+	if got, want := rec.Body.String(), "-- main.go --\npackage main\n"; got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+}
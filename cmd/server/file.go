@@ -13,7 +13,7 @@ func handleNewFile() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		dir, err := readMemoryDirectory(req)
 		if err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
+			writeRequestError(res, req, err)
 			return
 		}
 
@@ -22,8 +22,8 @@ func handleNewFile() http.HandlerFunc {
 			http.Error(res, "filename required", http.StatusBadRequest)
 			return
 		}
-		if !isPermittedFile(filename) {
-			http.Error(res, "file type not permitted", http.StatusBadRequest)
+		if err := checkArchiveFileName(filename); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -43,6 +43,10 @@ func handleNewFile() http.HandlerFunc {
 			Name: filename,
 			Data: content,
 		})
+		if err := checkArchive(dir.Archive); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
 		dir.ActiveFile = filename
 		dir.OpenFiles = append(dir.OpenFiles, filename)
 		dir.normalizeIDEState()
@@ -57,7 +61,7 @@ func handleDeleteFile() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		dir, err := readMemoryDirectory(req)
 		if err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
+			writeRequestError(res, req, err)
 			return
 		}
 
@@ -86,7 +90,7 @@ func handleSelectFile() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		dir, err := readMemoryDirectory(req)
 		if err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
+			writeRequestError(res, req, err)
 			return
 		}
 		filename := req.FormValue("select-filename")
@@ -116,7 +120,7 @@ func handleCloseFile() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		dir, err := readMemoryDirectory(req)
 		if err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
+			writeRequestError(res, req, err)
 			return
 		}
 		filename := req.FormValue("close-filename")
@@ -1,14 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"fmt"
 	"go/parser"
 	"go/token"
-	"io"
-	"log"
 	"net/http"
-	"os"
+	"net/url"
 	"path"
 	"slices"
 	"sort"
@@ -20,95 +20,122 @@ import (
 	"golang.org/x/tools/txtar"
 )
 
-func newGitHubClient() (*github.Client, error) {
+// defaultGitHubHost is the key for the github.com client in the map returned
+// by newGitHubClients.
+const defaultGitHubHost = "github.com"
+
+// gitHubConfig selects the GitHub hosts gists and repositories are imported
+// from.
+type gitHubConfig struct {
+	// BaseURL, when set, adds a GitHub Enterprise instance. UploadURL
+	// defaults to BaseURL.
+	BaseURL, UploadURL string
+	// Token and EnterpriseToken authenticate requests to github.com and the
+	// enterprise instance. They are read from GITHUB_TOKEN and
+	// GITHUB_ENTERPRISE_TOKEN only, so they are never in a config file or
+	// printed.
+	Token, EnterpriseToken string
+}
+
+// newGitHubClients returns the GitHub API clients keyed by the web host they
+// serve. A client for github.com is always present, and one for the GitHub
+// Enterprise instance at config.BaseURL when it is set.
+func newGitHubClients(config gitHubConfig) (map[string]*github.Client, error) {
 	var opts []github.ClientOptionsFunc
-	if value, isSet := os.LookupEnv("GITHUB_TOKEN"); isSet {
-		opts = append(opts, github.WithAuthToken(value))
+	if config.Token != "" {
+		opts = append(opts, github.WithAuthToken(config.Token))
+	}
+	client, err := github.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	clients := map[string]*github.Client{defaultGitHubHost: client}
+
+	if config.BaseURL == "" {
+		return clients, nil
 	}
-	return github.NewClient(opts...)
+	uploadURL := cmp.Or(config.UploadURL, config.BaseURL)
+	host, enterpriseClient, err := newEnterpriseGitHubClient(config.BaseURL, uploadURL, config.EnterpriseToken)
+	if err != nil {
+		return nil, err
+	}
+	clients[host] = enterpriseClient
+	return clients, nil
 }
 
-func newGistRateLimiter() *rate.Limiter {
-	return rate.NewLimiter(rate.Every(time.Second), 5)
+func newEnterpriseGitHubClient(baseURL, uploadURL, token string) (string, *github.Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid GitHub base URL: %w", err)
+	}
+	if u.Host == "" {
+		return "", nil, fmt.Errorf("GitHub base URL %q must be absolute", baseURL)
+	}
+	opts := []github.ClientOptionsFunc{github.WithEnterpriseURLs(baseURL, uploadURL)}
+	if token != "" {
+		opts = append(opts, github.WithAuthToken(token))
+	}
+	client, err := github.NewClient(opts...)
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimPrefix(u.Hostname(), "api."), client, nil
 }
 
-func handleGist(goVersion string, examples []Example, goExecPath string, ghClient *github.Client, limiter *rate.Limiter) http.HandlerFunc {
-	return func(res http.ResponseWriter, req *http.Request) {
-		gistID := req.PathValue("gistID")
-		if gistID == "" {
-			http.Error(res, "missing gist ID", http.StatusBadRequest)
-			return
-		}
+// defaultGistRateLimit allows a request to the GitHub API a second.
+var defaultGistRateLimit = rateBudget{Requests: 5, Per: 5 * time.Second}
 
-		if !limiter.Allow() {
-			http.Error(res, "rate limit exceeded, try again later", http.StatusTooManyRequests)
-			return
-		}
+// gistSource imports public gists from the GitHub host its client serves.
+// References have the form "{owner}/{gistID}".
+type gistSource struct {
+	client     *github.Client
+	limiter    *rate.Limiter
+	goExecPath string
+	goEnv      goEnvironment
+}
 
-		ctx, cancel := context.WithTimeout(req.Context(), 15*time.Second)
-		defer cancel()
-
-		gist, resp, err := ghClient.Gists.Get(ctx, gistID)
-		if err != nil {
-			if resp != nil {
-				switch resp.StatusCode {
-				case http.StatusNotFound:
-					http.Error(res, "gist not found", http.StatusNotFound)
-					return
-				case http.StatusForbidden:
-					http.Error(res, "GitHub API rate limit exceeded", http.StatusTooManyRequests)
-					return
-				}
-			}
-			log.Println("failed to fetch gist:", err)
-			http.Error(res, "failed to fetch gist", http.StatusBadGateway)
-			return
-		}
-		if !gist.GetPublic() {
-			http.Error(res, "gist not found", http.StatusNotFound)
-			return
-		}
+func (source gistSource) Import(ctx context.Context, ref string) (Project, error) {
+	_, gistID, _ := strings.Cut(ref, "/")
+	if gistID == "" || strings.Contains(gistID, "/") {
+		return Project{}, &importError{status: http.StatusBadRequest, message: "missing gist ID"}
+	}
 
-		dir, err := gistToMemoryDirectory(gist, goExecPath)
-		if err != nil {
-			log.Println("failed to convert gist:", err)
-			http.Error(res, "failed to load gist", http.StatusInternalServerError)
-			return
-		}
+	if !source.limiter.Allow() {
+		return Project{}, &importError{status: http.StatusTooManyRequests, message: "rate limit exceeded, try again later"}
+	}
 
-		data := Index{
-			CopyrightNotice: fmt.Sprintf(CopyrightNotice, time.Now().Year()),
-			GoVersion:       goVersion,
-			Examples:        slices.Clone(examples),
-			Name:            gistName(gist),
-			Dir:             dir,
+	gist, resp, err := source.client.Gists.Get(ctx, gistID)
+	if err != nil {
+		if resp != nil {
+			switch resp.StatusCode {
+			case http.StatusNotFound:
+				return Project{}, &importError{status: http.StatusNotFound, message: "gist not found"}
+			case http.StatusForbidden:
+				return Project{}, &importError{status: http.StatusTooManyRequests, message: "GitHub API rate limit exceeded"}
+			}
 		}
-		renderHTML(res, req, http.StatusOK, func(w io.Writer) error {
-			return templates.ExecuteTemplate(w, "index.html.template", data)
-		})
+		return Project{}, &importError{status: http.StatusBadGateway, message: "failed to fetch gist", err: err}
+	}
+	if !gist.GetPublic() {
+		return Project{}, &importError{status: http.StatusNotFound, message: "gist not found"}
 	}
+
+	dir, err := gistToMemoryDirectory(ctx, gist, source.goExecPath, source.goEnv)
+	if err != nil {
+		return Project{}, &importError{status: http.StatusInternalServerError, message: "failed to load gist", err: err}
+	}
+	return Project{Name: gistName(gist), Dir: dir}, nil
 }
 
-func gistToMemoryDirectory(gist *github.Gist, goExecPath string) (MemoryDirectory, error) {
+func gistToMemoryDirectory(ctx context.Context, gist *github.Gist, goExecPath string, goEnv goEnvironment) (MemoryDirectory, error) {
 	files := gistFilesSorted(gist)
 
 	// Case 1: Single .txt or .txtar file — parse as txtar
 	if len(files) == 1 {
 		ext := strings.ToLower(path.Ext(files[0].GetFilename()))
 		if ext == ".txt" || ext == ".txtar" {
-			archive := txtar.Parse([]byte(files[0].GetContent()))
-			var filtered []txtar.File
-			for _, f := range archive.Files {
-				if !isPermittedFile(f.Name) {
-					return MemoryDirectory{}, fmt.Errorf("file %s is not permitted", f.Name)
-				}
-				filtered = append(filtered, f)
-			}
-			archive.Files = filtered
-			dir := MemoryDirectory{Archive: archive, MultiFile: true}
-			expandNestedTxtar(&dir)
-			dir.normalizeIDEState()
-			return dir, nil
+			project, err := newProject("", txtar.Parse([]byte(files[0].GetContent())))
+			return project.Dir, err
 		}
 	}
 
@@ -116,29 +143,34 @@ func gistToMemoryDirectory(gist *github.Gist, goExecPath string) (MemoryDirector
 	if len(files) == 1 {
 		f := files[0]
 		if strings.ToLower(path.Ext(f.GetFilename())) == ".go" && isPackageMain([]byte(f.GetContent())) {
-			return singleGoFileDirectory(f.GetFilename(), []byte(f.GetContent()), goExecPath)
+			return singleGoFileDirectory(ctx, f.GetFilename(), []byte(f.GetContent()), goExecPath, goEnv)
 		}
 	}
 
-	// Case 3: Multi-file gist — collect all permitted files
+	// Case 3: Multi-file gist — collect all permitted files, decoding nested
+	// paths when the gist was exported with gistPathsFile
+	nested := slices.ContainsFunc(files, func(f github.GistFile) bool { return f.GetFilename() == gistPathsFile })
 	archive := &txtar.Archive{}
 	for _, f := range files {
 		name := f.GetFilename()
-		if !isPermittedFile(name) {
-			return MemoryDirectory{}, fmt.Errorf("file %s is not permitted", name)
+		if nested {
+			if name == gistPathsFile {
+				continue
+			}
+			name = gistFilenameToPath(name)
 		}
 		archive.Files = append(archive.Files, txtar.File{
 			Name: name,
 			Data: []byte(f.GetContent()),
 		})
 	}
-	dir := MemoryDirectory{Archive: archive, MultiFile: true}
-	expandNestedTxtar(&dir)
-	dir.normalizeIDEState()
-	return dir, nil
+	project, err := newProject("", archive)
+	return project.Dir, err
 }
 
-func singleGoFileDirectory(filename string, content []byte, goExecPath string) (MemoryDirectory, error) {
+// singleGoFileDirectory wraps a main package file in a module and runs go mod
+// tidy on it, which the import timeout of ctx limits.
+func singleGoFileDirectory(ctx context.Context, filename string, content []byte, goExecPath string, goEnv goEnvironment) (MemoryDirectory, error) {
 	goVersion := goVersionFromRuntime()
 
 	archive := &txtar.Archive{
@@ -149,6 +181,9 @@ func singleGoFileDirectory(filename string, content []byte, goExecPath string) (
 	}
 
 	dir := MemoryDirectory{Archive: archive, MultiFile: true}
+	if err := checkArchive(archive); err != nil {
+		return dir, err
+	}
 	dir.normalizeIDEState()
 	fsDir := FilesystemDirectory{MemoryDirectory: dir}
 	if err := fsDir.writeFiles(); err != nil {
@@ -156,11 +191,7 @@ func singleGoFileDirectory(filename string, content []byte, goExecPath string) (
 	}
 	defer func() { _ = fsDir.close() }()
 
-	env := mergeEnv(os.Environ(), goEnvOverride()...)
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	if err := fsDir.execGo(ctx, env, goExecPath, "mod", "tidy"); err != nil {
+	if err := fsDir.execGo(ctx, goEnv, goEnvOverride(), goExecPath, "mod", "tidy"); err != nil {
 		// If mod tidy fails, return the directory as-is with the basic go.mod
 		return dir, nil
 	}
@@ -212,3 +243,59 @@ func gistFilesSorted(gist *github.Gist) []github.GistFile {
 	})
 	return files
 }
+
+// gistPathSeparator stands in for "/" in gist filenames. Gists can not hold
+// directories so "pkg/util.go" is stored as "pkg__util.go".
+const gistPathSeparator = "__"
+
+// gistPathsFile marks gists exported with directories, so only their
+// filenames are decoded and a file like "a__b.go" in any other gist keeps
+// its name.
+const gistPathsFile = "go-playground.paths"
+
+const gistPathsFileContent = "Files named like pkg__util.go in this gist are at paths like pkg/util.go.\n"
+
+func gistFilenameToPath(filename string) string {
+	return strings.ReplaceAll(filename, gistPathSeparator, "/")
+}
+
+func pathToGistFilename(name string) (string, error) {
+	filename := strings.ReplaceAll(name, "/", gistPathSeparator)
+	if gistFilenameToPath(filename) != name {
+		return "", fmt.Errorf("file %s can not be stored in a gist", name)
+	}
+	return filename, nil
+}
+
+// memoryDirectoryToGist returns the GitHub API request creating a gist of
+// dir. When dir has directories, filenames are encoded with
+// gistPathSeparator and gistPathsFile is added.
+func memoryDirectoryToGist(dir MemoryDirectory, description string) (github.CreateGistRequest, error) {
+	gist := github.CreateGistRequest{
+		Description: github.Ptr(description),
+		Files:       make(map[github.GistFilename]*github.CreateGistFile, len(dir.Archive.Files)+1),
+	}
+	nested := slices.ContainsFunc(dir.Archive.Files, func(file txtar.File) bool { return strings.Contains(file.Name, "/") })
+	if nested {
+		gist.Files[gistPathsFile] = &github.CreateGistFile{Content: gistPathsFileContent}
+	}
+	for _, file := range dir.Archive.Files {
+		filename := file.Name
+		if nested {
+			var err error
+			if filename, err = pathToGistFilename(file.Name); err != nil {
+				return gist, err
+			}
+		}
+		if filename == gistPathsFile {
+			return gist, fmt.Errorf("file %s can not be stored in a gist", file.Name)
+		}
+		if len(bytes.TrimSpace(file.Data)) == 0 {
+			return gist, fmt.Errorf("file %s is empty, gists can not hold empty files", file.Name)
+		}
+		gist.Files[github.GistFilename(filename)] = &github.CreateGistFile{
+			Content: string(file.Data),
+		}
+	}
+	return gist, nil
+}
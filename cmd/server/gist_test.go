@@ -1,6 +1,10 @@
 package main
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 
@@ -70,7 +74,7 @@ func main() {}
 		},
 	}
 
-	dir, err := gistToMemoryDirectory(gist, "go")
+	dir, err := gistToMemoryDirectory(t.Context(), gist, "go", goEnvironment{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -119,7 +123,7 @@ func Test_gistToMemoryDirectory_multiFile(t *testing.T) {
 		},
 	}
 
-	if _, err := gistToMemoryDirectory(gist, "go"); err == nil {
+	if _, err := gistToMemoryDirectory(t.Context(), gist, "go", goEnvironment{}); err == nil {
 		t.Error(".env should cause an error", err)
 	} else if msg := err.Error(); !strings.Contains(msg, ".env") {
 		t.Errorf("should cause an error got %q", msg)
@@ -160,7 +164,7 @@ func Test_gistToMemoryDirectory_txtFile(t *testing.T) {
 		},
 	}
 
-	dir, err := gistToMemoryDirectory(gist, "go")
+	dir, err := gistToMemoryDirectory(t.Context(), gist, "go", goEnvironment{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -169,3 +173,163 @@ func Test_gistToMemoryDirectory_txtFile(t *testing.T) {
 		t.Fatalf("expected at least 2 files, got %d", len(dir.Archive.Files))
 	}
 }
+
+func Test_gistFilenameToPath(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{filename: "main.go", want: "main.go"},
+		{filename: "main_test.go", want: "main_test.go"},
+		{filename: "pkg__util.go", want: "pkg/util.go"},
+		{filename: "cmd__a__main.go", want: "cmd/a/main.go"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			if got := gistFilenameToPath(tt.filename); got != tt.want {
+				t.Errorf("gistFilenameToPath() = %q, want %q", got, tt.want)
+			}
+			filename, err := pathToGistFilename(tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filename != tt.filename {
+				t.Errorf("pathToGistFilename() = %q, want %q", filename, tt.filename)
+			}
+		})
+	}
+
+	if _, err := pathToGistFilename("pkg_/util.go"); err == nil {
+		t.Error("expected an error for a path that does not round-trip")
+	}
+}
+
+func Test_gistToMemoryDirectory_nested(t *testing.T) {
+	goMod := "module example.com\n\ngo 1.25\n"
+	mainGo := "package main\n\nfunc main() {}\n"
+	utilGo := "package util\n"
+
+	gist := &github.Gist{
+		Files: map[github.GistFilename]github.GistFile{
+			"go.mod":       {Filename: github.Ptr("go.mod"), Content: &goMod},
+			"main.go":      {Filename: github.Ptr("main.go"), Content: &mainGo},
+			"pkg__util.go": {Filename: github.Ptr("pkg__util.go"), Content: &utilGo},
+			gistPathsFile:  {Filename: github.Ptr(gistPathsFile), Content: github.Ptr(gistPathsFileContent)},
+		},
+	}
+
+	dir, err := gistToMemoryDirectory(t.Context(), gist, "go", goEnvironment{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range dir.Archive.Files {
+		names = append(names, f.Name)
+	}
+	if want := []string{"go.mod", "main.go", "pkg/util.go"}; !slices.Equal(names, want) {
+		t.Fatalf("got files %v, want %v", names, want)
+	}
+
+	body, err := memoryDirectoryToGist(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := body.Files["pkg__util.go"]; !ok || f.Content != utilGo {
+		t.Errorf("expected pkg__util.go in exported gist, got %v", body.Files)
+	}
+	if _, ok := body.Files[gistPathsFile]; !ok {
+		t.Errorf("expected %s in exported gist, got %v", gistPathsFile, body.Files)
+	}
+	if body.Public != nil {
+		t.Error("expected users to choose whether the exported gist is public")
+	}
+}
+
+func Test_gistToMemoryDirectory_flat(t *testing.T) {
+	dir := MemoryDirectory{Archive: &txtar.Archive{Files: []txtar.File{
+		{Name: "go.mod", Data: []byte("module example.com\n\ngo 1.25\n")},
+		{Name: "main.go", Data: []byte("package main\n\nfunc main() { y() }\n")},
+		{Name: "x__y.go", Data: []byte("package main\n\nfunc y() {}\n")},
+	}}}
+	body, err := memoryDirectoryToGist(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := body.Files[gistPathsFile]; ok {
+		t.Errorf("expected a project without directories to be exported as is, got %v", body.Files)
+	}
+
+	gist := &github.Gist{Files: make(map[github.GistFilename]github.GistFile)}
+	for filename, f := range body.Files {
+		gist.Files[filename] = github.GistFile{Filename: github.Ptr(string(filename)), Content: github.Ptr(f.Content)}
+	}
+	imported, err := gistToMemoryDirectory(t.Context(), gist, "go", goEnvironment{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range imported.Archive.Files {
+		names = append(names, f.Name)
+	}
+	if want := []string{"go.mod", "main.go", "x__y.go"}; !slices.Equal(names, want) {
+		t.Fatalf("got files %v, want %v", names, want)
+	}
+}
+
+func Test_handleGist_enterprise(t *testing.T) {
+	content := "-- go.mod --\nmodule example.com\n\ngo 1.25\n-- main.go --\npackage main\n\nfunc main() {}\n"
+	api := http.NewServeMux()
+	api.HandleFunc("GET /api/v3/gists/{gistID}", func(res http.ResponseWriter, req *http.Request) {
+		if req.PathValue("gistID") != "abc123" {
+			http.NotFound(res, req)
+			return
+		}
+		_ = json.NewEncoder(res).Encode(github.Gist{
+			ID:          github.Ptr("abc123"),
+			Public:      github.Ptr(true),
+			Description: github.Ptr("Enterprise Gist"),
+			Files: map[github.GistFilename]github.GistFile{
+				"playground.txtar": {Filename: github.Ptr("playground.txtar"), Content: &content},
+			},
+		})
+	})
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	host, client, err := newEnterpriseGitHubClient(srv.URL+"/", srv.URL+"/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gists := gistSource{client: client, limiter: defaultGistRateLimit.limiter(), goExecPath: "go"}
+	sources := new(Sources)
+	sources.Register(host+"/gist/", gists)
+	sources.Register("gist/"+host+"/", gists)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /gist.github.com/{owner}/{gistID}", handleImportPath("1.25", nil, sources, defaultImportTimeout))
+	mux.Handle("GET /gist/{host}/{owner}/{gistID}", handleImportPath("1.25", nil, sources, defaultImportTimeout))
+	mux.Handle("GET /import", handleImport("1.25", nil, sources, defaultImportTimeout))
+
+	for _, tt := range []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{name: "found", path: "/gist/" + host + "/someone/abc123", status: http.StatusOK},
+		{name: "missing gist", path: "/gist/" + host + "/someone/nope", status: http.StatusNotFound},
+		{name: "import enterprise URL", path: "/import?src=https://" + host + "/gist/someone/abc123", status: http.StatusOK},
+		{name: "unknown host", path: "/gist/example.com/someone/abc123", status: http.StatusBadRequest},
+		{name: "github.com not configured", path: "/gist.github.com/someone/abc123", status: http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusOK && !strings.Contains(rec.Body.String(), "Enterprise Gist") {
+				t.Errorf("expected gist description in page")
+			}
+		})
+	}
+}
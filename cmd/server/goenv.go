@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// hostGoEnv lists the variables copied from the server's environment into the
+// environment of go subprocesses. Nothing else is copied, so tokens and other
+// secrets the server holds are not visible to user code or build tools.
+var hostGoEnv = []string{
+	"PATH",
+	"GOPROXY", "GONOPROXY", "GOSUMDB", "GONOSUMDB", "GOPRIVATE", "GOINSECURE",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+}
+
+// goEnvironment is the environment go subprocesses run with.
+type goEnvironment struct {
+	// host holds the variables in hostGoEnv that are set on the server.
+	host []string
+	// moduleProxy holds the GOPROXY, GOFLAGS, and GONOSUMDB settings pointing
+	// builds at the offline module proxy. It is empty when the host's
+	// settings are used.
+	moduleProxy []string
+	// sandbox limits the resources go subprocesses may use.
+	sandbox sandbox
+}
+
+func newGoEnvironment() goEnvironment {
+	var goEnv goEnvironment
+	for _, key := range hostGoEnv {
+		if value, isSet := os.LookupEnv(key); isSet {
+			goEnv.host = append(goEnv.host, key+"="+value)
+		}
+	}
+	return goEnv
+}
+
+// env returns the environment for a go subprocess whose caches and temporary
+// files live in home, a directory belonging to a single request. It holds
+// the copied host variables, the module proxy settings, the variables that
+// isolate the toolchain, and then override.
+func (goEnv goEnvironment) env(home string, override ...string) []string {
+	isolated := []string{
+		"HOME=" + home,
+		"GOENV=off",
+		"GOTOOLCHAIN=local",
+		"GOCACHE=" + filepath.Join(home, "cache"),
+		"GOMODCACHE=" + filepath.Join(home, "mod"),
+		"GOPATH=" + filepath.Join(home, "go"),
+		"GOTMPDIR=" + filepath.Join(home, "tmp"),
+		"TMPDIR=" + filepath.Join(home, "tmp"),
+		"GOFLAGS=-modcacherw",
+		"CGO_ENABLED=0",
+	}
+	return mergeEnv(nil, slices.Concat(goEnv.host, isolated, goEnv.moduleProxy, override)...)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGoEnvironment_env(t *testing.T) {
+	goExecPath, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not found")
+	}
+	const secret = "ghp_not-a-real-token"
+	t.Setenv("GITHUB_TOKEN", secret)
+	t.Setenv("GITHUB_ENTERPRISE_TOKEN", secret)
+	t.Setenv("GOFLAGS", "-toolexec="+secret)
+	t.Setenv("GOPROXY", "https://goproxy.example.com")
+
+	goEnv := newGoEnvironment()
+	home := t.TempDir()
+	if err := os.Mkdir(filepath.Join(home, "tmp"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	env := goEnv.env(home, goEnvOverride()...)
+	for _, v := range env {
+		if strings.Contains(v, secret) {
+			t.Errorf("environment variable %q leaks a secret", v)
+		}
+	}
+
+	cmd := exec.CommandContext(t.Context(), goExecPath, "env", "-json")
+	cmd.Env = env
+	cmd.Dir = home
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), secret) {
+		t.Errorf("go env output leaks a secret:\n%s", out)
+	}
+	var goEnvOutput map[string]string
+	if err := json.Unmarshal(out, &goEnvOutput); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"GOOS":        "js",
+		"GOARCH":      "wasm",
+		"GOPROXY":     "https://goproxy.example.com",
+		"GOTOOLCHAIN": "local",
+		"GOCACHE":     filepath.Join(home, "cache"),
+		"GOMODCACHE":  filepath.Join(home, "mod"),
+		"GOPATH":      filepath.Join(home, "go"),
+		"GOFLAGS":     "-modcacherw",
+	} {
+		if got := goEnvOutput[key]; got != want {
+			t.Errorf("got %s=%q, want %q", key, got, want)
+		}
+	}
+
+	goEnv.moduleProxy = moduleProxyEnv("http://localhost:8080/goproxy")
+	env = goEnv.env(home)
+	if !slices.Contains(env, "GOPROXY=http://localhost:8080/goproxy") || !slices.Contains(env, "GOFLAGS=-mod=mod -modcacherw") {
+		t.Errorf("expected the module proxy settings to replace the host's, got %q", env)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/time/rate"
+	"golang.org/x/tools/txtar"
+)
+
+const (
+	defaultGoProxy    = "https://proxy.golang.org"
+	maxModuleZipBytes = 1 << 24
+)
+
+var errModuleNotFound = errors.New("module not found")
+
+// goProxyFromEnv returns the first proxy URL in a GOPROXY list, skipping the
+// "direct" and "off" keywords, or defaultGoProxy when the list is empty. It
+// returns "" when the list has no proxy URL, like "off", so modules are not
+// downloaded from a proxy the operator did not choose.
+func goProxyFromEnv(value string) string {
+	if strings.TrimSpace(value) == "" {
+		return defaultGoProxy
+	}
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '|' }) {
+		entry = strings.TrimSpace(entry)
+		switch entry {
+		case "", "direct", "off":
+			continue
+		}
+		return strings.TrimSuffix(entry, "/")
+	}
+	return ""
+}
+
+// moduleSource imports a directory of a module version from a module proxy.
+// References have the form "{module}@{version}/{subdir}". The project gets a
+// synthesized go.mod that requires the module.
+type moduleSource struct {
+	goVersion string
+	// proxyURL is "" when GOPROXY has no proxy URL.
+	proxyURL string
+	limiter  *rate.Limiter
+}
+
+func (source moduleSource) Import(ctx context.Context, ref string) (Project, error) {
+	modPath, rest, ok := strings.Cut(ref, "@")
+	if !ok {
+		return Project{}, &importError{status: http.StatusBadRequest, message: "module version required, use {module}@{version}/{subdir}"}
+	}
+	version, subdir, _ := strings.Cut(rest, "/")
+	subdir = path.Clean("/" + subdir)[1:]
+	if source.proxyURL == "" {
+		return Project{}, &importError{status: http.StatusServiceUnavailable, message: "module proxy disabled, GOPROXY has no proxy URL"}
+	}
+	if err := module.Check(modPath, version); err != nil {
+		return Project{}, &importError{status: http.StatusBadRequest, message: err.Error()}
+	}
+	if err := currentPolicy().checkModule(modPath, version); err != nil {
+		return Project{}, &importError{status: http.StatusForbidden, message: err.Error()}
+	}
+
+	if !source.limiter.Allow() {
+		return Project{}, &importError{status: http.StatusTooManyRequests, message: "rate limit exceeded, try again later"}
+	}
+
+	zipBuffer, err := fetchModuleZip(ctx, source.proxyURL, modPath, version)
+	if err != nil {
+		if errors.Is(err, errModuleNotFound) {
+			return Project{}, &importError{status: http.StatusNotFound, message: "module not found"}
+		}
+		return Project{}, &importError{status: http.StatusBadGateway, message: "failed to fetch module", err: err}
+	}
+
+	archive, err := moduleZipDirectory(zipBuffer, modPath, version, subdir)
+	if err != nil {
+		return Project{}, err
+	}
+	archive.Files = append(archive.Files, txtar.File{
+		Name: "go.mod",
+		Data: fmt.Appendf(nil, "module example.com\n\ngo %s\n\nrequire %s %s\n", source.goVersion, modPath, version),
+	})
+	archiveFS, err := txtar.FS(archive)
+	if err != nil {
+		return Project{}, err
+	}
+	dir, rejected, err := newMemoryDirectoryFromFS(archiveFS)
+	if err != nil {
+		return Project{}, err
+	}
+	return Project{Name: path.Join(modPath+"@"+version, subdir), Dir: dir, Rejected: rejected}, nil
+}
+
+// fetchModuleZip downloads a module zip using the module proxy protocol.
+// Both http(s) and file URLs are supported for proxyURL.
+func fetchModuleZip(ctx context.Context, proxyURL, modPath, version string) ([]byte, error) {
+	escapedPath, err := module.EscapePath(modPath)
+	if err != nil {
+		return nil, err
+	}
+	escapedVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GOPROXY: %w", err)
+	}
+	zipPath := path.Join(escapedPath, "@v", escapedVersion+".zip")
+
+	if u.Scheme == "file" {
+		buf, err := os.ReadFile(filepath.Join(filepath.FromSlash(u.Path), filepath.FromSlash(zipPath)))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errModuleNotFound
+		}
+		return buf, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.JoinPath(zipPath).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAndIgnoreError(res.Body)
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return nil, errModuleNotFound
+	default:
+		return nil, fmt.Errorf("unexpected status from module proxy: %s", res.Status)
+	}
+	buf, err := io.ReadAll(io.LimitReader(res.Body, maxModuleZipBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maxModuleZipBytes {
+		return nil, fmt.Errorf("module zip exceeds %d bytes", maxModuleZipBytes)
+	}
+	return buf, nil
+}
+
+// moduleZipDirectory returns the files under subdir in a module zip. Entries
+// in a module zip are prefixed with "{module}@{version}/"; the prefix and
+// subdir are removed from the returned file names. Files that are not
+// permitted are returned without their content. The others are read up to the
+// limits checkArchive enforces, so a zip bomb is not expanded in memory.
+func moduleZipDirectory(zipBuffer []byte, modPath, version, subdir string) (*txtar.Archive, error) {
+	zr, err := zip.NewReader(bytes.NewReader(zipBuffer), int64(len(zipBuffer)))
+	if err != nil {
+		return nil, err
+	}
+	prefix := modPath + "@" + version + "/"
+	if subdir != "" {
+		prefix += subdir + "/"
+	}
+	archive := new(txtar.Archive)
+	files, total := 0, 0
+	for _, f := range zr.File {
+		name, ok := strings.CutPrefix(f.Name, prefix)
+		if !ok || f.FileInfo().IsDir() {
+			continue
+		}
+		switch name {
+		case "go.mod", "go.sum":
+			if subdir != "" {
+				return nil, fmt.Errorf("directory %s is a separate module", subdir)
+			}
+			continue
+		}
+		if !isPermittedFile(name) {
+			archive.Files = append(archive.Files, txtar.File{Name: name})
+			continue
+		}
+		if files++; files > maxArchiveFiles {
+			return nil, &ArchiveError{Err: errTooManyFiles, Detail: fmt.Sprintf("more than %d files", maxArchiveFiles)}
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		buf, err := io.ReadAll(io.LimitReader(rc, maxArchiveFileBytes+1))
+		closeAndIgnoreError(rc)
+		if err != nil {
+			return nil, err
+		}
+		if len(buf) > maxArchiveFileBytes {
+			return nil, &ArchiveError{File: name, Err: errFileTooLarge, Detail: fmt.Sprintf("the limit is %d bytes", maxArchiveFileBytes)}
+		}
+		if total += len(buf); total > maxArchiveBytes {
+			return nil, &ArchiveError{Err: errArchiveTooLarge, Detail: fmt.Sprintf("more than %d bytes", maxArchiveBytes)}
+		}
+		archive.Files = append(archive.Files, txtar.File{Name: name, Data: buf})
+	}
+	if len(archive.Files) == 0 {
+		return nil, &importError{status: http.StatusNotFound, message: fmt.Sprintf("directory %s not found in %s@%s", subdir, modPath, version)}
+	}
+	return archive, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func Test_goProxyFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "", want: defaultGoProxy},
+		{value: "direct", want: ""},
+		{value: "https://proxy.golang.org,direct", want: "https://proxy.golang.org"},
+		{value: "off", want: ""},
+		{value: "direct|https://goproxy.example.com/", want: "https://goproxy.example.com"},
+		{value: "file:///tmp/proxy", want: "file:///tmp/proxy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := goProxyFromEnv(tt.value); got != tt.want {
+				t.Errorf("goProxyFromEnv(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// writeFileProxy writes a module zip into a directory laid out for the module
+// proxy protocol and returns a file URL for GOPROXY.
+func writeFileProxy(t *testing.T, escapedPath, version string, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	versionDir := filepath.Join(dir, filepath.FromSlash(escapedPath), "@v")
+	if err := os.MkdirAll(versionDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(versionDir, version+".zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return "file://" + filepath.ToSlash(dir)
+}
+
+func Test_handleModule(t *testing.T) {
+	const prefix = "github.com/crhntr/dom@v0.1.0/"
+	proxyURL := writeFileProxy(t, "github.com/crhntr/dom", "v0.1.0", map[string]string{
+		prefix + "go.mod":                       "module github.com/crhntr/dom\n",
+		prefix + "dom.go":                       "package dom\n",
+		prefix + "examples/hello/main.go":       "package main\n\nfunc main() {}\n",
+		prefix + "examples/hello/page.gohtml":   "<p>hello</p>\n",
+		prefix + "examples/hello/.hidden.go":    "package main\n",
+		prefix + "examples/counter/main.go":     "package main\n",
+		prefix + "examples/hello/sub/helper.go": "package sub\n",
+	})
+
+	sources := new(Sources)
+	sources.Register("mod/", moduleSource{goVersion: "1.25", proxyURL: proxyURL, limiter: rate.NewLimiter(rate.Inf, 1)})
+	mux := http.NewServeMux()
+	mux.Handle("GET /mod/{module...}", handleImportPath("1.25", nil, sources, defaultImportTimeout))
+
+	for _, tt := range []struct {
+		name     string
+		path     string
+		status   int
+		contains []string
+	}{
+		{
+			name:   "subdirectory",
+			path:   "/mod/github.com/crhntr/dom@v0.1.0/examples/hello",
+			status: http.StatusOK,
+			contains: []string{
+				"require github.com/crhntr/dom v0.1.0",
+				`data-file="main.go"`,
+				`data-file="page.gohtml"`,
+				`data-file="sub/helper.go"`,
+			},
+		},
+		{name: "missing version", path: "/mod/github.com/crhntr/dom/examples/hello", status: http.StatusBadRequest},
+		{name: "not permitted", path: "/mod/github.com/example/other@v1.0.0", status: http.StatusForbidden},
+		{name: "unknown version", path: "/mod/github.com/crhntr/dom@v0.2.0", status: http.StatusNotFound},
+		{name: "unknown directory", path: "/mod/github.com/crhntr/dom@v0.1.0/nope", status: http.StatusNotFound, contains: []string{"directory nope not found"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			body := rec.Body.String()
+			for _, want := range tt.contains {
+				if !strings.Contains(body, want) {
+					t.Errorf("expected response to contain %q", want)
+				}
+			}
+			if strings.Contains(body, `data-file=".hidden.go"`) {
+				t.Error("hidden file should not be loaded")
+			}
+		})
+	}
+
+	disabled := new(Sources)
+	disabled.Register("mod/", moduleSource{goVersion: "1.25", proxyURL: goProxyFromEnv("off"), limiter: rate.NewLimiter(rate.Inf, 1)})
+	rec := httptest.NewRecorder()
+	handleImportPath("1.25", nil, disabled, defaultImportTimeout).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/mod/github.com/crhntr/dom@v0.1.0", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "module proxy disabled") {
+		t.Errorf("expected GOPROXY=off to disable module imports, got status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func Test_moduleZipDirectory_limits(t *testing.T) {
+	moduleZip := func(files map[string][]byte) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, content := range files {
+			w, err := zw.Create("example.com/bomb@v1.0.0/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(content); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	large := bytes.Repeat([]byte("a"), maxArchiveFileBytes+1)
+	if _, err := moduleZipDirectory(moduleZip(map[string][]byte{"bomb.go": large}), "example.com/bomb", "v1.0.0", ""); !errors.Is(err, errFileTooLarge) {
+		t.Errorf("expected errFileTooLarge, got %v", err)
+	}
+	files := make(map[string][]byte)
+	for i := range maxArchiveBytes/maxArchiveFileBytes + 1 {
+		files[fmt.Sprintf("f%d.go", i)] = large[:maxArchiveFileBytes]
+	}
+	if _, err := moduleZipDirectory(moduleZip(files), "example.com/bomb", "v1.0.0", ""); !errors.Is(err, errArchiveTooLarge) {
+		t.Errorf("expected errArchiveTooLarge, got %v", err)
+	}
+	archive, err := moduleZipDirectory(moduleZip(map[string][]byte{"main.go": []byte("package main\n"), "bomb.bin": large}), "example.com/bomb", "v1.0.0", "")
+	if err != nil {
+		t.Fatalf("expected files that are not permitted to be skipped, got %v", err)
+	}
+	for _, file := range archive.Files {
+		if file.Name == "bomb.bin" && file.Data != nil {
+			t.Error("expected a file that is not permitted to be left unread")
+		}
+	}
+}
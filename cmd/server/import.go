@@ -0,0 +1,169 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/txtar"
+)
+
+// Project is an archive loaded into the editor along with the name shown in
+// the page title. Rejected lists files left out because they are not
+// permitted.
+type Project struct {
+	Name     string
+	Dir      MemoryDirectory
+	Rejected []string
+}
+
+// newProject expands nested txtar files, checks the archive with
+// checkArchive, and returns a Project ready to render in the editor.
+func newProject(name string, archive *txtar.Archive) (Project, error) {
+	dir := MemoryDirectory{Archive: archive, MultiFile: true}
+	if err := dir.expandAndCheckArchive(); err != nil {
+		return Project{}, err
+	}
+	dir.normalizeIDEState()
+	return Project{Name: name, Dir: dir}, nil
+}
+
+// Source loads a Project from a reference. The reference is the part of the
+// src string following the prefix the Source was registered with. Projects
+// in a request body, posted by the editor or uploaded, are not named by a
+// reference and are loaded with loadAndRender instead.
+type Source interface {
+	Import(ctx context.Context, ref string) (Project, error)
+}
+
+type SourceFunc func(ctx context.Context, ref string) (Project, error)
+
+func (fn SourceFunc) Import(ctx context.Context, ref string) (Project, error) { return fn(ctx, ref) }
+
+// Sources is a registry of Source keyed by URL scheme (like "example:") or
+// path prefix (like "gist.github.com/"). The longest matching key wins.
+type Sources struct {
+	sources map[string]Source
+}
+
+func (sources *Sources) Register(prefix string, source Source) {
+	if sources.sources == nil {
+		sources.sources = make(map[string]Source)
+	}
+	sources.sources[prefix] = source
+}
+
+// Import loads the project referenced by src. Leading "https://" and
+// "http://" are ignored so links copied from a browser work.
+func (sources *Sources) Import(ctx context.Context, src string) (Project, error) {
+	for _, scheme := range []string{"https://", "http://"} {
+		src = strings.TrimPrefix(src, scheme)
+	}
+	var match string
+	for prefix := range sources.sources {
+		if strings.HasPrefix(src, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	if match == "" {
+		return Project{}, &importError{status: http.StatusBadRequest, message: fmt.Sprintf("unsupported source %q", src)}
+	}
+	return sources.sources[match].Import(ctx, strings.TrimPrefix(src, match))
+}
+
+// importError carries the status code and message a handler should respond
+// with when a Source fails. The wrapped error is logged, not shown to users.
+type importError struct {
+	status  int
+	message string
+	err     error
+}
+
+func (err *importError) Error() string {
+	if err.err != nil {
+		return err.message + ": " + err.err.Error()
+	}
+	return err.message
+}
+
+func (err *importError) Unwrap() error { return err.err }
+
+func writeImportError(res http.ResponseWriter, req *http.Request, err error) {
+	var ie *importError
+	if !errors.As(err, &ie) {
+		writeRequestError(res, req, err)
+		return
+	}
+	if ie.err != nil {
+		log.Println(ie.message+":", ie.err)
+	}
+	http.Error(res, ie.message, cmp.Or(ie.status, http.StatusBadRequest))
+}
+
+// handleImport loads the project named by the "src" query parameter.
+func handleImport(goVersion string, examples []Example, sources *Sources, timeout time.Duration) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		src := strings.TrimSpace(req.FormValue("src"))
+		if src == "" {
+			http.Error(res, "missing src", http.StatusBadRequest)
+			return
+		}
+		importAndRender(res, req, goVersion, examples, sources, src, timeout)
+	}
+}
+
+// handleImportPath loads the project named by the request path, so
+// "/gist.github.com/{owner}/{gistID}" works like
+// "/import?src=gist.github.com/{owner}/{gistID}".
+func handleImportPath(goVersion string, examples []Example, sources *Sources, timeout time.Duration) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		importAndRender(res, req, goVersion, examples, sources, strings.TrimPrefix(req.URL.Path, "/"), timeout)
+	}
+}
+
+func importAndRender(res http.ResponseWriter, req *http.Request, goVersion string, examples []Example, sources *Sources, src string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+
+	auditSource(req.Context(), src)
+	loadAndRender(res, req, goVersion, examples, func() (Project, error) {
+		return sources.Import(ctx, src)
+	})
+}
+
+// loadAndRender renders the project load returns in the editor. Every route
+// loading a project goes through it, so each is audited and reports errors
+// the same way.
+func loadAndRender(res http.ResponseWriter, req *http.Request, goVersion string, examples []Example, load func() (Project, error)) {
+	project, err := load()
+	if err != nil {
+		writeImportError(res, req, err)
+		return
+	}
+	auditArchive(req.Context(), project.Dir.Archive)
+	renderIndex(res, req, goVersion, examples, project)
+}
+
+func renderIndex(res http.ResponseWriter, req *http.Request, goVersion string, examples []Example, project Project) {
+	project.Dir.Name = cmp.Or(project.Name, project.Dir.Name)
+	data := Index{
+		CopyrightNotice: fmt.Sprintf(CopyrightNotice, time.Now().Year()),
+		GoVersion:       goVersion,
+		Examples:        slices.Clone(examples),
+		Name:            project.Dir.Name,
+		Dir:             project.Dir,
+		RejectedFiles:   project.Rejected,
+		Nonce:           cspNonce(req.Context()),
+		Libraries:       editorLibraries,
+	}
+	renderHTML(res, req, http.StatusOK, func(w io.Writer) error {
+		return templates.ExecuteTemplate(w, "index.html.template", data)
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+func TestSources_Import(t *testing.T) {
+	record := func(name string) SourceFunc {
+		return func(_ context.Context, ref string) (Project, error) {
+			return Project{Name: name + ":" + ref}, nil
+		}
+	}
+	sources := new(Sources)
+	sources.Register("example:", record("example"))
+	sources.Register("github.com/", record("repository"))
+	sources.Register("gist.github.com/", record("gist"))
+	sources.Register("ghe.example.com/", record("repository"))
+	sources.Register("ghe.example.com/gist/", record("gist"))
+
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{src: "example:hello-world", want: "example:hello-world"},
+		{src: "github.com/o/r/tree/main/cmd", want: "repository:o/r/tree/main/cmd"},
+		{src: "https://github.com/o/r/tree/main", want: "repository:o/r/tree/main"},
+		{src: "https://gist.github.com/o/123", want: "gist:o/123"},
+		{src: "http://ghe.example.com/gist/o/123", want: "gist:o/123"},
+		{src: "ghe.example.com/o/r/tree/v1", want: "repository:o/r/tree/v1"},
+	} {
+		t.Run(tt.src, func(t *testing.T) {
+			project, err := sources.Import(t.Context(), tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if project.Name != tt.want {
+				t.Errorf("got %q, want %q", project.Name, tt.want)
+			}
+		})
+	}
+
+	_, err := sources.Import(t.Context(), "ftp://example.com/file")
+	var ie *importError
+	if !errors.As(err, &ie) || ie.status != http.StatusBadRequest {
+		t.Errorf("expected a bad request import error, got %v", err)
+	}
+}
+
+func Test_newProject(t *testing.T) {
+	if _, err := newProject("", &txtar.Archive{Files: []txtar.File{{Name: ".env"}}}); err == nil {
+		t.Error("expected hidden file to be rejected")
+	}
+	project, err := newProject("nested", &txtar.Archive{Files: []txtar.File{
+		{Name: "go.mod", Data: []byte("module example.com\n")},
+		{Name: "pkg.txtar", Data: []byte("-- util.go --\npackage pkg\n")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := project.Dir.Archive.Files[1].Name; got != "pkg/util.go" {
+		t.Errorf("expected nested txtar to be expanded, got %q", got)
+	}
+	if project.Dir.ActiveFile != "go.mod" {
+		t.Errorf("expected IDE state to be normalized, got active file %q", project.Dir.ActiveFile)
+	}
+}
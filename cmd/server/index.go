@@ -1,19 +1,13 @@
 package main
 
 import (
-	"archive/zip"
-	"bytes"
 	"cmp"
-	"errors"
-	"fmt"
-	"io"
+	"context"
 	"io/fs"
-	"log"
 	"net/http"
 	"path"
 	"slices"
 	"strings"
-	"time"
 
 	"golang.org/x/tools/txtar"
 )
@@ -23,141 +17,60 @@ type Index struct {
 	Examples                   []Example
 	Name                       string
 	Dir                        MemoryDirectory
+	RejectedFiles              []string
+	// Nonce permits the inline script to run, see cspNonce.
+	Nonce     string
+	Libraries []LibraryTag
 }
 
 type Example struct {
 	Name string
 }
 
-func handleIndexPage(goVersion string, examples []Example) http.HandlerFunc {
-	const defaultExampleName = "hello-world"
-	return func(res http.ResponseWriter, req *http.Request) {
-		data := Index{
-			CopyrightNotice: fmt.Sprintf(CopyrightNotice, time.Now().Year()),
-			GoVersion:       goVersion,
-			Examples:        slices.Clone(examples),
-			Name:            defaultExampleName,
-		}
-
-		if q := req.URL.Query(); q.Has("example") {
-			data.Name = cmp.Or(strings.TrimSpace(q.Get("example")), defaultExampleName)
+// exampleSource imports the examples in dir. References are example names.
+func exampleSource(dir fs.FS, examples []Example) SourceFunc {
+	return func(_ context.Context, name string) (Project, error) {
+		if !slices.ContainsFunc(examples, func(e Example) bool { return e.Name == name }) {
+			return Project{}, &importError{status: http.StatusNotFound, message: "example not found"}
 		}
-		if data.Name != "" {
-			if index := slices.IndexFunc(data.Examples, func(e Example) bool {
-				return e.Name == data.Name
-			}); index >= 0 && index < len(data.Examples) {
-				buf, err := fs.ReadFile(assets, path.Join("assets/examples", data.Name+".txtar"))
-				if err != nil {
-					log.Println("failed to read example", err)
-					http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-					return
-				}
-				data.Dir.Archive = txtar.Parse(buf)
-				data.Dir.MultiFile = true
-				data.Dir.normalizeIDEState()
-			}
+		buf, err := fs.ReadFile(dir, name+".txtar")
+		if err != nil {
+			return Project{}, &importError{status: http.StatusInternalServerError, message: "failed to read example", err: err}
 		}
-		renderHTML(res, req, http.StatusOK, func(w io.Writer) error {
-			return templates.ExecuteTemplate(w, "index.html.template", data)
-		})
+		return newProject(name, txtar.Parse(buf))
 	}
 }
 
-func handlePOSTIndex(goVersion string, examples []Example) http.HandlerFunc {
+func handleIndexPage(goVersion string, examples []Example, sources *Sources) http.HandlerFunc {
+	const defaultExampleName = "hello-world"
 	return func(res http.ResponseWriter, req *http.Request) {
-		dir, err := readMemoryDirectory(req)
-		if err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
-			return
+		name := defaultExampleName
+		if q := req.URL.Query(); q.Has("example") {
+			name = cmp.Or(strings.TrimSpace(q.Get("example")), defaultExampleName)
 		}
-		data := Index{
-			CopyrightNotice: fmt.Sprintf(CopyrightNotice, time.Now().Year()),
-			GoVersion:       goVersion,
-			Examples:        examples,
-			Name:            "",
-			Dir:             dir,
+		var src string
+		if q := req.URL.Query(); q.Has("share") {
+			src = "share:" + q.Get("share")
+		} else if slices.ContainsFunc(examples, func(e Example) bool { return e.Name == name }) {
+			src = "example:" + name
 		}
-		renderHTML(res, req, http.StatusOK, func(w io.Writer) error {
-			return templates.ExecuteTemplate(w, "index.html.template", data)
-		})
-	}
-}
-
-func handleGETInstall(goVersion string) http.HandlerFunc {
-	type Data struct {
-		GoVersion       string
-		CopyrightNotice string
-	}
-
-	return func(res http.ResponseWriter, req *http.Request) {
-		data := Data{
-			CopyrightNotice: fmt.Sprintf(CopyrightNotice, time.Now().Year()),
-			GoVersion:       goVersion,
+		if src == "" {
+			renderIndex(res, req, goVersion, examples, Project{Name: name})
+			return
 		}
-		renderHTML(res, req, http.StatusOK, func(w io.Writer) error {
-			return templates.ExecuteTemplate(w, "upload.html.template", data)
+		auditSource(req.Context(), src)
+		loadAndRender(res, req, goVersion, examples, func() (Project, error) {
+			return sources.Import(req.Context(), src)
 		})
 	}
 }
 
-func handlePOSTInstall(goVersion string, examples []Example) http.HandlerFunc {
+// handlePOSTIndex loads the project the editor posts, see readMemoryDirectory.
+func handlePOSTIndex(goVersion string, examples []Example) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		var zipBuffer []byte
-		defer closeAndIgnoreError(req.Body)
-		if pr, err := req.MultipartReader(); err == nil {
-			for {
-				part, err := pr.NextPart()
-				if err != nil {
-					if errors.Is(err, io.EOF) {
-						break
-					}
-					http.Error(res, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-					return
-				}
-				if part.FormName() != "zip" {
-					http.Error(res, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-					return
-				}
-				buf, err := io.ReadAll(part)
-				if err != nil {
-					http.Error(res, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-					return
-				}
-				zipBuffer = buf
-				break
-			}
-		} else {
-			body := io.LimitReader(req.Body, maxBodyBytes)
-			defer closeAndIgnoreError(req.Body)
-			buf, err := io.ReadAll(body)
-			if err != nil {
-				http.Error(res, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-				return
-			}
-			zipBuffer = buf
-		}
-
-		zr, err := zip.NewReader(bytes.NewReader(zipBuffer), int64(len(zipBuffer)))
-		if err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
-			return
-		}
-		dir, err := newMemoryDirectoryFromFS(zr)
-		if err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		data := Index{
-			CopyrightNotice: fmt.Sprintf(CopyrightNotice, time.Now().Year()),
-			GoVersion:       goVersion,
-			Examples:        slices.Clone(examples),
-			Name:            "Upload",
-			Dir:             dir,
-		}
-
-		renderHTML(res, req, http.StatusOK, func(w io.Writer) error {
-			return templates.ExecuteTemplate(w, "index.html.template", data)
+		loadAndRender(res, req, goVersion, examples, func() (Project, error) {
+			dir, err := readMemoryDirectory(req)
+			return Project{Dir: dir}, err
 		})
 	}
 }
@@ -171,7 +84,7 @@ func isPermittedFile(in string) bool {
 		return false
 	}
 	switch base {
-	case "LICENSE", "go.sum":
+	case "LICENSE", "go.sum", "go.work", "go.work.sum":
 		return true
 	}
 	switch strings.ToLower(path.Ext(in)) {
@@ -0,0 +1,127 @@
+package main
+
+//go:generate go run . -download-libraries assets/_vendor
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// cdnLibrariesURL is where the editor loads frontendLibraries from unless they
+// are served by the server.
+const cdnLibrariesURL = defaultLibrarySource + "/ajax/libs"
+
+// frontendLibrary is a file of a JavaScript or CSS library the editor loads.
+type frontendLibrary struct {
+	Name, Version, File string
+	// Integrity is the subresource integrity of the file published on cdnjs.
+	Integrity string
+}
+
+// pinnedLibraries are the library files of the editor in the order the page
+// loads them. Building with the selfhosted tag embeds each of them from
+// assets/_vendor, see libraries_selfhosted.go.
+var pinnedLibraries = []frontendLibrary{
+	{Name: "htmx", Version: "2.0.4", File: "htmx.min.js", Integrity: "sha512-2kIcAizYXhIn8TzUvqzEDZNuDZ+aW7yE/+f1HJHXFjQcGNfv1kqzJSTBRBSlOgp6B/KZsz1K0a3ZTqP9dnxioQ=="},
+	{Name: "codemirror", Version: "6.65.7", File: "codemirror.min.js", Integrity: "sha512-8RnEqURPUc5aqFEN04aQEiPlSAdE0jlFS/9iGgUyNtwFnSKCXhmB6ZTNl7LnDtDWKabJIASzXrzD0K+LYexU9g=="},
+	{Name: "codemirror", Version: "6.65.7", File: "codemirror.min.css", Integrity: "sha512-uf06llspW44/LZpHzHT6qBOIVODjWtv4MxCricRxkzvopAlSWnTf6hpZTFxuuZcuNE9CBQhqE0Seu1CoRk84nQ=="},
+	{Name: "codemirror", Version: "6.65.7", File: "mode/go/go.min.js", Integrity: "sha512-dh8pBX6P5WZ63k5cSrF64G2QqKAHnLCjnP7vZOmz4peYWedM5lXyH/AqpUldSFBtubTK54kmwN6XAn/T2sVDVQ=="},
+}
+
+func (lib frontendLibrary) path() string { return path.Join(lib.Name, lib.Version, lib.File) }
+
+// LibraryTag is a script or stylesheet element of the editor page.
+type LibraryTag struct {
+	URL, Integrity string
+	Stylesheet     bool
+	// CrossOrigin is set for libraries loaded from another origin.
+	CrossOrigin bool
+}
+
+// editorLibraries are the elements index.html.template loads the libraries
+// with. main replaces them with localLibraryTags when the server serves the
+// libraries itself.
+var editorLibraries = cdnLibraryTags(pinnedLibraries)
+
+func cdnLibraryTags(libs []frontendLibrary) []LibraryTag {
+	tags := make([]LibraryTag, 0, len(libs))
+	for _, lib := range libs {
+		tags = append(tags, LibraryTag{
+			URL:         cdnLibrariesURL + "/" + lib.path(),
+			Integrity:   lib.Integrity,
+			Stylesheet:  path.Ext(lib.File) == ".css",
+			CrossOrigin: true,
+		})
+	}
+	return tags
+}
+
+// localLibraryTags returns the elements to load libs from dir, served at
+// prefix, computing their integrity from the files. It fails when a file is
+// missing or is not the pinned version.
+func localLibraryTags(dir fs.FS, prefix string, libs []frontendLibrary) ([]LibraryTag, error) {
+	tags := make([]LibraryTag, 0, len(libs))
+	for _, lib := range libs {
+		buf, err := fs.ReadFile(dir, lib.path())
+		if err != nil {
+			return nil, fmt.Errorf("%s %s is not embedded, run go generate and build with -tags selfhosted: %w", lib.Name, lib.Version, err)
+		}
+		integrity := subresourceIntegrity(buf)
+		if integrity != lib.Integrity {
+			return nil, fmt.Errorf("%s has integrity %s, not the pinned %s", lib.path(), integrity, lib.Integrity)
+		}
+		tags = append(tags, LibraryTag{
+			URL:        prefix + lib.path(),
+			Integrity:  integrity,
+			Stylesheet: path.Ext(lib.File) == ".css",
+		})
+	}
+	return tags, nil
+}
+
+func subresourceIntegrity(buf []byte) string {
+	sum := sha512.Sum512(buf)
+	return "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// downloadLibraries writes libs, fetched from baseURL and checked against
+// their pinned integrity, to dir.
+func downloadLibraries(ctx context.Context, client *http.Client, baseURL, dir string, libs []frontendLibrary) error {
+	for _, lib := range libs {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/"+lib.path(), nil)
+		if err != nil {
+			return err
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		buf, err := io.ReadAll(res.Body)
+		closeAndIgnoreError(res.Body)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to download %s: %s", req.URL, res.Status)
+		}
+		if integrity := subresourceIntegrity(buf); integrity != lib.Integrity {
+			return fmt.Errorf("%s has integrity %s, not the pinned %s", req.URL, integrity, lib.Integrity)
+		}
+		filename := filepath.Join(dir, filepath.FromSlash(lib.path()))
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filename, buf, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
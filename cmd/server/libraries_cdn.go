@@ -0,0 +1,12 @@
+//go:build !selfhosted
+
+package main
+
+import "embed"
+
+// vendoredLibraries is empty unless the server is built with the selfhosted
+// tag, see libraries_selfhosted.go.
+var vendoredLibraries embed.FS
+
+// serveLibrariesByDefault is the default of -serve-libraries.
+const serveLibrariesByDefault = false
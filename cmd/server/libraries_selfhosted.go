@@ -0,0 +1,18 @@
+//go:build selfhosted
+
+package main
+
+import "embed"
+
+// vendoredLibraries holds the pinnedLibraries downloaded by go generate. The
+// directory starts with an underscore so the assets embed leaves it out.
+// Building fails when one of the files is missing.
+//
+//go:embed assets/_vendor/htmx/2.0.4/htmx.min.js
+//go:embed assets/_vendor/codemirror/6.65.7/codemirror.min.js
+//go:embed assets/_vendor/codemirror/6.65.7/codemirror.min.css
+//go:embed assets/_vendor/codemirror/6.65.7/mode/go/go.min.js
+var vendoredLibraries embed.FS
+
+// serveLibrariesByDefault is the default of -serve-libraries.
+const serveLibrariesByDefault = true
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func Test_localLibraryTags(t *testing.T) {
+	content := []byte("htmx = {}")
+	libs := []frontendLibrary{
+		{Name: "htmx", Version: "2.0.4", File: "htmx.min.js", Integrity: subresourceIntegrity(content)},
+		{Name: "codemirror", Version: "6.65.7", File: "codemirror.min.css", Integrity: subresourceIntegrity([]byte(".CodeMirror {}"))},
+	}
+	dir := fstest.MapFS{
+		"htmx/2.0.4/htmx.min.js":               {Data: content},
+		"codemirror/6.65.7/codemirror.min.css": {Data: []byte(".CodeMirror {}")},
+	}
+	tags, err := localLibraryTags(dir, "/assets/vendor/", libs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags[0] != (LibraryTag{URL: "/assets/vendor/htmx/2.0.4/htmx.min.js", Integrity: libs[0].Integrity}) || !tags[1].Stylesheet {
+		t.Errorf("unexpected tags %+v", tags)
+	}
+
+	dir["codemirror/6.65.7/codemirror.min.css"] = &fstest.MapFile{Data: []byte(".CodeMirror { color: red }")}
+	if _, err := localLibraryTags(dir, "/assets/vendor/", libs); err == nil || !strings.Contains(err.Error(), "not the pinned") {
+		t.Errorf("expected a changed file to be rejected, got %v", err)
+	}
+	delete(dir, "htmx/2.0.4/htmx.min.js")
+	if _, err := localLibraryTags(dir, "/assets/vendor/", libs); err == nil || !strings.Contains(err.Error(), "htmx 2.0.4 is not embedded") {
+		t.Errorf("expected a missing file to be reported, got %v", err)
+	}
+}
+
+func Test_downloadLibraries(t *testing.T) {
+	content := []byte("CodeMirror.defineMode('go')")
+	lib := frontendLibrary{Name: "codemirror", Version: "6.65.7", File: "mode/go/go.min.js", Integrity: subresourceIntegrity(content)}
+	cdn := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/ajax/libs/codemirror/6.65.7/mode/go/go.min.js" {
+			http.NotFound(res, req)
+			return
+		}
+		_, _ = res.Write(content)
+	}))
+	t.Cleanup(cdn.Close)
+
+	dir := t.TempDir()
+	if err := downloadLibraries(t.Context(), cdn.Client(), cdn.URL+"/ajax/libs", dir, []frontendLibrary{lib}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "codemirror", "6.65.7", "mode", "go", "go.min.js"))
+	if err != nil || string(got) != string(content) {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	lib.Integrity = pinnedLibraries[0].Integrity
+	if err := downloadLibraries(t.Context(), cdn.Client(), cdn.URL+"/ajax/libs", dir, []frontendLibrary{lib}); err == nil {
+		t.Error("expected a file that does not match the pinned integrity to be rejected")
+	}
+}
+
+// Test_pinnedLibraries_selfhosted checks the selfhosted build embeds each of
+// the pinned libraries, so building it fails when one is missing.
+func Test_pinnedLibraries_selfhosted(t *testing.T) {
+	source, err := os.ReadFile("libraries_selfhosted.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lib := range pinnedLibraries {
+		if directive := "//go:embed assets/_vendor/" + lib.path() + "\n"; !strings.Contains(string(source), directive) {
+			t.Errorf("expected %q in libraries_selfhosted.go", directive)
+		}
+	}
+}
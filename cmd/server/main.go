@@ -2,9 +2,10 @@ package main
 
 import (
 	"bytes"
-	"cmp"
 	"context"
 	"embed"
+	"errors"
+	"flag"
 	"html/template"
 	"io"
 	"io/fs"
@@ -12,71 +13,221 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
-	"path"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 )
 
-const (
-	maxBodyBytes   = 1 << 14
-	maxHeaderBytes = 1 << 13
-)
-
 var (
 	//go:embed assets
 	assets embed.FS
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == sandboxShimArg {
+		runSandboxShim(os.Args[2:])
+	}
+
+	cfg, cmd, err := loadConfig(os.Args[0], os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cmd.PrintConfig {
+		if err := cfg.print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if cmd.DownloadLibraries != "" {
+		if err := downloadLibraries(context.Background(), http.DefaultClient, cdnLibrariesURL, cmd.DownloadLibraries, pinnedLibraries); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	var vendoredLibrariesDir fs.FS
+	if cfg.ServeLibraries {
+		if vendoredLibrariesDir, err = fs.Sub(vendoredLibraries, "assets/_vendor"); err != nil {
+			log.Fatal(err)
+		}
+		if editorLibraries, err = localLibraryTags(vendoredLibrariesDir, "/assets/vendor/", pinnedLibraries); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := cfg.Sandbox.check(); err != nil {
+		log.Fatal(err)
+	}
+	if err := watchPolicy(context.Background(), cfg.Policy, policyReloadInterval); err != nil {
+		log.Fatal(err)
+	}
+
 	gv, err := readGoVersion(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
 	goVersion := string(gv)
-	exampleDirectories, err := fs.Glob(assets, "assets/examples/*.txtar")
+	examplesDir, err := cfg.examplesDir()
 	if err != nil {
 		log.Fatal(err)
 	}
-	examples := make([]Example, 0, len(exampleDirectories))
-	for _, dir := range exampleDirectories {
-		examples = append(examples, Example{Name: strings.TrimSuffix(path.Base(dir), ".txtar")})
+	exampleFiles, err := fs.Glob(examplesDir, "*.txtar")
+	if err != nil {
+		log.Fatal(err)
+	}
+	examples := make([]Example, 0, len(exampleFiles))
+	for _, name := range exampleFiles {
+		examples = append(examples, Example{Name: strings.TrimSuffix(name, ".txtar")})
 	}
 	goExecPath, err := exec.LookPath("go")
 	if err != nil {
 		log.Fatal(err)
 	}
+	if cmd.SeedModuleCache {
+		if cfg.ModuleCache == "" {
+			log.Fatal("seeding requires a module cache")
+		}
+		if err := seedModuleCache(context.Background(), goExecPath, cfg.ModuleCache, currentPolicy()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	wasmExecJS, err := readWASMExecJS(context.Background(), goExecPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	security, err := securityPolicy{ScriptSources: cfg.ScriptSources, StyleSources: cfg.StyleSources}.withRunScripts(wasmExecJS)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	mux := http.NewServeMux()
+	proxyURL := goProxyFromEnv(cfg.GoProxy)
+	goEnv := newGoEnvironment()
+	goEnv.sandbox = cfg.Sandbox
+	var moduleProxy http.Handler = http.NotFoundHandler()
+	if cfg.ModuleCache != "" {
+		dir, err := filepath.Abs(cfg.ModuleCache)
+		if err != nil {
+			log.Fatal(err)
+		}
+		proxy := moduleCacheProxy{dir: dir}
+		moduleProxy = proxy
+		proxyURL = "file://" + filepath.ToSlash(proxy.downloadDir())
+		goEnv.moduleProxy = moduleProxyEnv("http://localhost:" + cfg.Port + "/goproxy")
+		if cfg.Sandbox.IsolateNetwork {
+			// The server is not reachable from a new network namespace, so
+			// builds read the module cache directly. It must be readable by
+			// the sandbox user.
+			goEnv.moduleProxy = moduleProxyEnv(proxyURL)
+		}
+	}
 
-	mux.Handle("GET /assets/", http.FileServer(http.FS(assets)))
-	mux.Handle("GET /", handleIndexPage(goVersion, examples))
-	mux.Handle("POST /", handlePOSTIndex(goVersion, examples))
+	ghClients, err := newGitHubClients(cfg.GitHub)
+	if err != nil {
+		log.Fatal(err)
+	}
+	gistLimiter := cfg.GistRateLimit.limiter()
 
-	mux.Handle("GET /go/version", handleVersion(goVersion))
-	mux.Handle("POST /go/run", handleRun(goExecPath))
-	mux.Handle("POST /go/mod/tidy", handleModTidy(goExecPath))
-	mux.Handle("POST /fmt", handleFmt())
-	mux.Handle("POST /file/new", handleNewFile())
-	mux.Handle("POST /file/delete", handleDeleteFile())
-	mux.Handle("POST /file/select", handleSelectFile())
-	mux.Handle("POST /file/close", handleCloseFile())
-	mux.HandleFunc("POST /download", handleDownload)
-
-	ghClient, err := newGitHubClient()
+	sources := new(Sources)
+	sources.Register("example:", exampleSource(examplesDir, examples))
+	sources.Register("share:", SourceFunc(shareSource))
+	for host, client := range ghClients {
+		gists := gistSource{client: client, limiter: gistLimiter, goExecPath: goExecPath, goEnv: goEnv}
+		if host == defaultGitHubHost {
+			sources.Register("gist.github.com/", gists)
+		} else {
+			sources.Register(host+"/gist/", gists)
+			sources.Register("gist/"+host+"/", gists)
+		}
+		sources.Register(host+"/", repositorySource{client: client, limiter: gistLimiter})
+	}
+	sources.Register("mod/", moduleSource{
+		goVersion: goVersion,
+		proxyURL:  proxyURL,
+		limiter:   gistLimiter,
+	})
+
+	builds := newBuildQueue(runtime.NumCPU())
+	clients := newClientLimiter(cfg.RateLimits, cfg.BuildQuota, cfg.TrustedProxyHeader)
+	build := func(h http.Handler) http.Handler { return clients.limit(routeClassBuild, h) }
+	edit := func(h http.Handler) http.Handler { return clients.limit(routeClassEdit, h) }
+	load := func(h http.Handler) http.Handler { return clients.limit(routeClassImport, h) }
+
+	var audit *auditLog
+	if cfg.AuditLog != "" {
+		audit, err = newAuditLog(cfg.AuditLog, cfg.AuditRetention, cfg.AuditKeepArchives, cfg.TrustedProxyHeader)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	authenticator, err := cfg.Auth.authenticator(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
-	gistLimiter := newGistRateLimiter()
-	mux.Handle("GET /gist.github.com/{owner}/{gistID}", handleGist(goVersion, examples, goExecPath, ghClient, gistLimiter))
+
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /assets/", http.FileServer(http.FS(assets)))
+	if vendoredLibrariesDir != nil {
+		mux.Handle("GET /assets/vendor/", http.StripPrefix("/assets/vendor/", http.FileServer(http.FS(vendoredLibrariesDir))))
+	}
+	indexPage := handleIndexPage(goVersion, examples, sources)
+	importIndexPage := audit.record("import", indexPage)
+	mux.Handle("GET /", http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		// Only loading a shared project or an example is audited, not every
+		// visit to the editor.
+		if q := req.URL.Query(); q.Has("share") || q.Has("example") {
+			importIndexPage.ServeHTTP(res, req)
+			return
+		}
+		indexPage.ServeHTTP(res, req)
+	}))
+	mux.Handle("POST /", edit(handlePOSTIndex(goVersion, examples)))
+	mux.Handle("GET /import", audit.record("import", load(handleImport(goVersion, examples, sources, cfg.ImportTimeout))))
+
+	mux.Handle("GET /go/version", handleVersion(goVersion))
+	mux.Handle("GET /usage", handleUsage(clients))
+	mux.Handle("POST /go/run", audit.record("run", build(handleRun(goExecPath, goEnv, wasmExecJS, builds, security, cfg.BuildTimeout))))
+	mux.Handle("POST /go/build", audit.record("build", build(handleBuild(goExecPath, goEnv, builds, cfg.BuildTimeout))))
+	mux.Handle("POST /go/mod/tidy", audit.record("tidy", build(handleModTidy(goExecPath, goEnv, builds, cfg.TidyTimeout))))
+	mux.Handle("POST /fmt", audit.record("fmt", edit(handleFmt())))
+	mux.Handle("POST /file/new", edit(handleNewFile()))
+	mux.Handle("POST /file/delete", edit(handleDeleteFile()))
+	mux.Handle("POST /file/select", edit(handleSelectFile()))
+	mux.Handle("POST /file/close", edit(handleCloseFile()))
+	mux.Handle("POST /download", edit(http.HandlerFunc(handleDownload)))
+	mux.Handle("POST /download/webapp", audit.record("build", build(handleDownloadWebApp(goExecPath, goEnv, wasmExecJS, builds, cfg.BuildTimeout))))
+	mux.Handle("POST /share", edit(handleShare()))
+
+	importPath := audit.record("import", load(handleImportPath(goVersion, examples, sources, cfg.ImportTimeout)))
+	mux.Handle("GET /gist.github.com/{owner}/{gistID}", importPath)
+	mux.Handle("GET /gist/{host}/{owner}/{gistID}", importPath)
+	mux.Handle("GET /github.com/{owner}/{repo}/tree/{ref}", importPath)
+	mux.Handle("GET /github.com/{owner}/{repo}/tree/{ref}/{path...}", importPath)
+	mux.Handle("GET /mod/{module...}", importPath)
+
+	mux.Handle("GET /goproxy/{path...}", moduleProxy)
 
 	mux.HandleFunc("GET /upload", handleGETInstall(goVersion))
-	mux.HandleFunc("POST /upload", handlePOSTInstall(goVersion, examples))
+	mux.Handle("POST /upload", audit.record("upload", load(handlePOSTInstall(goVersion, examples))))
 
-	addr := ":" + cmp.Or(os.Getenv("PORT"), "8080")
+	if loginRoutes, ok := authenticator.(http.Handler); ok {
+		mux.Handle(loginPathPrefix, loginRoutes)
+	}
+	var handler = cfg.BodyLimits.handler(mux, cfg.MaxBodyBytes)
+	if authenticator != nil {
+		handler = requireAuth(authenticator, handler)
+	}
+	handler = security.handler(handler)
+
+	addr := ":" + cfg.Port
 	server := &http.Server{
-		Handler:        mux,
+		Handler:        handler,
 		Addr:           addr,
-		MaxHeaderBytes: maxHeaderBytes, // 8 kibibytes
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
 	}
 	if err := server.ListenAndServe(); err != nil {
 		panic(err)
@@ -89,6 +240,7 @@ var (
 
 	templates = template.Must(template.New("").Funcs(template.FuncMap{
 		"bytesToString": func(in []byte) string { return string(in) },
+		"nativeTargets": func() []string { return nativeTargets },
 	}).ParseFS(templateSource, "templates/*.template"))
 )
 
@@ -108,23 +260,11 @@ var (
 
 func closeAndIgnoreError(c io.Closer) { _ = c.Close() }
 
-func removeZeros[T comparable](in []T) []T {
-	filtered := in[:0]
-	for _, p := range in {
-		var zero T
-		if p == zero {
-			continue
-		}
-		filtered = append(filtered, p)
-	}
-	return filtered
-}
-
 func handleFmt() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		dir, err := readMemoryDirectory(req)
 		if err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
+			writeRequestError(res, req, err)
 			return
 		}
 		if err := dir.fmt(); err != nil {
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"slices"
 	"strings"
 )
 
@@ -55,17 +56,19 @@ func handleVersion(goVersion string) http.HandlerFunc {
 
 func goEnvOverride() []string { return []string{"GOOS=js", "GOARCH=wasm"} }
 
+// mergeEnv returns env with the variables in additional set. Later values
+// replace earlier ones with the same key.
 func mergeEnv(env []string, additional ...string) []string {
-	l := len(env) + len(env)
-	m := make(map[string]string, l)
-	keys := make([]string, 0, l)
-	for _, v := range append(additional, env...) {
-		x := strings.Index(v, "=")
-		k := v[:x]
-		keys = append(keys, k)
-		m[k] = v[x+1:]
+	m := make(map[string]string, len(env)+len(additional))
+	keys := make([]string, 0, len(env)+len(additional))
+	for _, v := range append(slices.Clone(env), additional...) {
+		k, value, _ := strings.Cut(v, "=")
+		if _, ok := m[k]; !ok {
+			keys = append(keys, k)
+		}
+		m[k] = value
 	}
-	result := make([]string, 0, l)
+	result := make([]string, 0, len(keys))
 	for _, k := range keys {
 		result = append(result, k+"="+m[k])
 	}
@@ -4,24 +4,31 @@ import (
 	"context"
 	"io"
 	"net/http"
-	"os"
 	"time"
 )
 
-func handleModTidy(goExecPath string) http.HandlerFunc {
-	env := mergeEnv(os.Environ(), goEnvOverride()...)
-
+func handleModTidy(goExecPath string, goEnv goEnvironment, queue buildQueue, timeout time.Duration) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		dir, err := newRequestDirectory(req)
 		if err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
+			writeRequestError(res, req, err)
 			return
 		}
+		defer func() {
+			_ = dir.close()
+		}()
 
-		ctx, cancel := context.WithTimeout(req.Context(), time.Minute)
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
 		defer cancel()
 
-		if err := dir.execGo(ctx, env, goExecPath, "mod", "tidy"); err != nil {
+		release, err := queue.acquire(ctx)
+		if err != nil {
+			writeAcquireError(res, err)
+			return
+		}
+		defer release()
+
+		if err := dir.execGo(ctx, goEnv, goEnvOverride(), goExecPath, "mod", "tidy"); err != nil {
 			http.Error(res, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -30,6 +37,14 @@ func handleModTidy(goExecPath string) http.HandlerFunc {
 			http.Error(res, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if err := dir.checkDependencies(); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := dir.checkModuleGraph(ctx, goEnv, goEnvOverride(), goExecPath, "./..."); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		renderHTML(res, req, http.StatusOK, func(w io.Writer) error {
 			return templates.ExecuteTemplate(w, "editor", dir)
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_handleModTidy_buildQueue(t *testing.T) {
+	queue := newBuildQueue(1)
+	release, err := queue.acquire(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	form := url.Values{"txtar-content": {"-- go.mod --\nmodule example.com\n\ngo 1.26\n-- main.go --\npackage main\n\nfunc main() {}\n"}}
+	req := httptest.NewRequest(http.MethodPost, "/go/mod/tidy", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handleModTidy("go", goEnvironment{}, queue, 10*time.Millisecond).ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected tidy to wait for a build slot, got status %d: %s", rec.Code, rec.Body.String())
+	}
+}
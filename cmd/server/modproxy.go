@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// moduleCacheProxy serves the module proxy protocol from the download cache of
+// a module cache, the directory GOMODCACHE names. Version lists and .info and
+// .mod files are served for every cached module because resolving a module
+// graph needs them. Module zips, which hold source code, are only served for
+// versions the current policy permits.
+type moduleCacheProxy struct {
+	dir string
+}
+
+// downloadDir returns the directory laid out for the module proxy protocol.
+// It may be used as a file:// GOPROXY.
+func (proxy moduleCacheProxy) downloadDir() string {
+	return filepath.Join(proxy.dir, "cache", "download")
+}
+
+// moduleProxyEnv returns the settings that point go subprocesses at the
+// module proxy at proxyURL. The checksum database can not be reached from an
+// offline network, so checksums are recorded but not verified against it;
+// the seeded cache is trusted like any private proxy. GOFLAGS keeps
+// -modcacherw from goEnvironment.env so module caches can be removed.
+func moduleProxyEnv(proxyURL string) []string {
+	return []string{
+		"GOPROXY=" + proxyURL,
+		"GOFLAGS=-mod=mod -modcacherw",
+		"GONOSUMDB=*",
+	}
+}
+
+// ServeHTTP handles "GET /goproxy/{path...}" requests like
+// "/goproxy/github.com/crhntr/dom/@v/v0.5.4.zip".
+func (proxy moduleCacheProxy) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	escapedPath, file, ok := strings.Cut(req.PathValue("path"), "/@v/")
+	if !ok || strings.Contains(file, "/") {
+		http.NotFound(res, req)
+		return
+	}
+	modPath, err := module.UnescapePath(escapedPath)
+	if err != nil {
+		http.NotFound(res, req)
+		return
+	}
+	if file != "list" {
+		ext := path.Ext(file)
+		version, err := module.UnescapeVersion(strings.TrimSuffix(file, ext))
+		if err != nil || module.Check(modPath, version) != nil {
+			http.NotFound(res, req)
+			return
+		}
+		switch ext {
+		case ".info", ".mod":
+		case ".zip":
+			if err := currentPolicy().checkModule(modPath, version); err != nil {
+				http.Error(res, err.Error(), http.StatusForbidden)
+				return
+			}
+		default:
+			http.NotFound(res, req)
+			return
+		}
+	}
+	name := filepath.Join(proxy.downloadDir(), filepath.FromSlash(escapedPath), "@v", file)
+	f, err := os.Open(name)
+	if err != nil {
+		http.NotFound(res, req)
+		return
+	}
+	defer closeAndIgnoreError(f)
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(res, req)
+		return
+	}
+	http.ServeContent(res, req, file, info.ModTime(), f)
+}
+
+// seedModuleCache downloads every module the policy permits into the module
+// cache at dir using the host's GOPROXY. A module is downloaded at the version
+// its "=" constraint names or else at its latest version. The .mod files of
+// its module graph are downloaded along with it, and so are the zips of the
+// permitted modules at the versions that graph selects.
+func seedModuleCache(ctx context.Context, goExecPath, dir string, policy *Policy) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	env := mergeEnv(os.Environ(), "GOMODCACHE="+dir, "GOFLAGS=-mod=mod", "GOWORK=off")
+	var errs []error
+	for _, rule := range policy.Modules {
+		version := "latest"
+		for _, constraint := range rule.Constraints {
+			if constraint.Op == "=" {
+				version = constraint.Version
+			}
+		}
+		if err := seedModule(ctx, goExecPath, env, policy, rule.Path+"@"+version); err != nil {
+			errs = append(errs, fmt.Errorf("failed to seed %s@%s: %w", rule.Path, version, err))
+			continue
+		}
+		log.Printf("seeded %s@%s", rule.Path, version)
+	}
+	return errors.Join(errs...)
+}
+
+func seedModule(ctx context.Context, goExecPath string, env []string, policy *Policy, query string) error {
+	tmp, err := os.MkdirTemp("", "seed-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+	if err := os.WriteFile(filepath.Join(tmp, "go.mod"), []byte("module seed\n"), 0o644); err != nil {
+		return err
+	}
+	goCommand := func(args ...string) ([]byte, error) {
+		cmd := exec.CommandContext(ctx, goExecPath, args...)
+		cmd.Dir = tmp
+		cmd.Env = env
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("go %s: %w: %s", strings.Join(args, " "), err, stderr.String())
+		}
+		return out, nil
+	}
+	if _, err := goCommand("get", query); err != nil {
+		return err
+	}
+	out, err := goCommand("list", "-m", "-f", "{{if not .Main}}{{.Path}}@{{.Version}}{{end}}", "all")
+	if err != nil {
+		return err
+	}
+	queryPath, _, _ := strings.Cut(query, "@")
+	args := []string{"mod", "download"}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		modPath, version, ok := strings.Cut(sc.Text(), "@")
+		if !ok {
+			continue
+		}
+		if err := policy.checkModule(modPath, version); err != nil {
+			if modPath == queryPath {
+				return err
+			}
+			continue
+		}
+		args = append(args, sc.Text())
+	}
+	_, err = goCommand(args...)
+	return err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"archive/zip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeModuleCache writes the download cache entries for a module version
+// into the module cache at dir.
+func writeModuleCache(t *testing.T, dir, escapedPath, version string, files map[string]string) {
+	t.Helper()
+	versionDir := filepath.Join(dir, "cache", "download", filepath.FromSlash(escapedPath), "@v")
+	if err := os.MkdirAll(versionDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"list":               version + "\n",
+		version + ".info":    `{"Version":"` + version + `","Time":"2024-01-01T00:00:00Z"}`,
+		version + ".mod":     files["go.mod"],
+		version + ".ziphash": "",
+	} {
+		if err := os.WriteFile(filepath.Join(versionDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Create(filepath.Join(versionDir, version+".zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(escapedPath + "@" + version + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_moduleCacheProxy(t *testing.T) {
+	dir := t.TempDir()
+	writeModuleCache(t, dir, "github.com/crhntr/dom", "v0.1.0", map[string]string{
+		"go.mod": "module github.com/crhntr/dom\n",
+		"dom.go": "package dom\n",
+	})
+	writeModuleCache(t, dir, "example.com/secret", "v1.0.0", map[string]string{
+		"go.mod":    "module example.com/secret\n",
+		"secret.go": "package secret\n",
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /goproxy/{path...}", moduleCacheProxy{dir: dir})
+
+	for _, tt := range []struct {
+		path string
+		want int
+	}{
+		{path: "/goproxy/github.com/crhntr/dom/@v/list", want: http.StatusOK},
+		{path: "/goproxy/github.com/crhntr/dom/@v/v0.1.0.info", want: http.StatusOK},
+		{path: "/goproxy/github.com/crhntr/dom/@v/v0.1.0.zip", want: http.StatusOK},
+		{path: "/goproxy/github.com/crhntr/dom/@v/v0.2.0.zip", want: http.StatusNotFound},
+		{path: "/goproxy/github.com/crhntr/dom/@v/v0.1.0.ziphash", want: http.StatusNotFound},
+		{path: "/goproxy/example.com/secret/@v/v1.0.0.mod", want: http.StatusOK},
+		{path: "/goproxy/example.com/secret/@v/v1.0.0.zip", want: http.StatusForbidden},
+		{path: "/goproxy/github.com/crhntr/dom/@latest", want: http.StatusNotFound},
+		{path: "/goproxy/github.com/crhntr/%2e%2e/@v/list", want: http.StatusNotFound},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+
+	if testing.Short() {
+		t.Skip("skipping go mod download in short mode")
+	}
+	goExecPath, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not found")
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	download := func(query string) error {
+		cmd := exec.CommandContext(t.Context(), goExecPath, "mod", "download", query)
+		cmd.Dir = t.TempDir()
+		cmd.Env = mergeEnv(os.Environ(), append(moduleProxyEnv(server.URL+"/goproxy"),
+			"GOMODCACHE="+t.TempDir(),
+			"GOFLAGS=-modcacherw",
+		)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Log(string(out))
+		}
+		return err
+	}
+	if err := download("github.com/crhntr/dom@v0.1.0"); err != nil {
+		t.Errorf("expected a permitted module to download: %v", err)
+	}
+	if err := download("example.com/secret@v1.0.0"); err == nil {
+		t.Error("expected a module that is not permitted to fail")
+	}
+}
+
+func Test_mergeEnv(t *testing.T) {
+	got := mergeEnv([]string{"HOME=/root", "GOOS=linux", "PATH=/bin"}, "GOOS=js", "GOARCH=wasm")
+	want := []string{"HOME=/root", "GOOS=js", "PATH=/bin", "GOARCH=wasm"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
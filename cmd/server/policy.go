@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+	"golang.org/x/tools/txtar"
+)
+
+// The files a Policy is read from.
+const (
+	packagePolicyFile = "import_allow_list.txt"
+	modulePolicyFile  = "module_allow_list.txt"
+	sourcePolicyFile  = "source_policy.txt"
+)
+
+var (
+	//go:embed assets/import_allow_list.txt
+	permittedPackagesString string
+	//go:embed assets/module_allow_list.txt
+	permittedModulesString string
+	//go:embed assets/source_policy.txt
+	sourcePolicyString string
+)
+
+// Source rules that may be forbidden in source_policy.txt.
+const (
+	sourceRuleLinkname = "linkname"
+	sourceRuleUnsafe   = "unsafe"
+	sourceRuleCgo      = "cgo"
+	sourceRuleGenerate = "generate"
+)
+
+var sourceRules = []string{sourceRuleLinkname, sourceRuleUnsafe, sourceRuleCgo, sourceRuleGenerate}
+
+// Policy decides which packages and module versions a project may depend on
+// and which source constructs it may use.
+type Policy struct {
+	// Packages are the standard library packages that may be imported.
+	Packages []string
+	// Modules are the modules that may be required, directly or not.
+	Modules []ModuleRule
+	// Forbidden are the source rules, like "linkname", files may not break.
+	Forbidden []string
+}
+
+// ModuleRule permits a module at versions matching every constraint.
+type ModuleRule struct {
+	Path        string
+	Constraints []VersionConstraint
+}
+
+// VersionConstraint compares a module version with Version using Op, one of
+// "=", "!=", "<", "<=", ">", or ">=". Versions are compared as semver, so
+// "<v2" permits every v1 release.
+type VersionConstraint struct {
+	Op      string
+	Version string
+}
+
+func (constraint VersionConstraint) String() string { return constraint.Op + constraint.Version }
+
+func (constraint VersionConstraint) allows(version string) bool {
+	c := semver.Compare(version, constraint.Version)
+	switch constraint.Op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// PolicyViolation is a broken rule at a line of a project file. Line is zero
+// when the violation is not tied to a line.
+type PolicyViolation struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (violation *PolicyViolation) Error() string {
+	if violation.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", violation.File, violation.Line, violation.Message)
+	}
+	return violation.File + ": " + violation.Message
+}
+
+var embeddedPolicy = sync.OnceValue(func() *Policy {
+	policy, err := parsePolicy(permittedPackagesString, permittedModulesString, sourcePolicyString)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+})
+
+// checkDependencies checks archive against the current policy.
+func checkDependencies(archive *txtar.Archive) ([]Module, error) {
+	return currentPolicy().Check(archive)
+}
+
+// parsePolicy reads a Policy from the content of the package, module, and
+// source policy files. Blank lines and lines starting with "#" are ignored.
+//
+// Each line of the module file holds a module path optionally followed by
+// version constraints, like "github.com/crhntr/dom >=v0.5.0 <v1 !=v0.5.1".
+// Each line of the source file is "forbid" followed by a rule name.
+func parsePolicy(packages, modules, source string) (*Policy, error) {
+	policy := new(Policy)
+	var errs []error
+	for line, fields := range policyLines(packages) {
+		if len(fields) != 1 {
+			errs = append(errs, fmt.Errorf("%s:%d: expected a single package path", packagePolicyFile, line))
+			continue
+		}
+		policy.Packages = append(policy.Packages, fields[0])
+	}
+	for line, fields := range policyLines(modules) {
+		rule, err := parseModuleRule(fields)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", modulePolicyFile, line, err))
+			continue
+		}
+		policy.Modules = append(policy.Modules, rule)
+	}
+	for line, fields := range policyLines(source) {
+		if len(fields) != 2 || fields[0] != "forbid" || !slices.Contains(sourceRules, fields[1]) {
+			errs = append(errs, fmt.Errorf("%s:%d: expected forbid followed by one of %s", sourcePolicyFile, line, strings.Join(sourceRules, ", ")))
+			continue
+		}
+		policy.Forbidden = append(policy.Forbidden, fields[1])
+	}
+	return policy, errors.Join(errs...)
+}
+
+// policyLines yields the fields of each line in content that is not blank or
+// a comment along with its line number.
+func policyLines(content string) func(yield func(int, []string) bool) {
+	return func(yield func(int, []string) bool) {
+		sc := bufio.NewScanner(strings.NewReader(content))
+		for line := 1; sc.Scan(); line++ {
+			fields := strings.Fields(sc.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			if !yield(line, fields) {
+				return
+			}
+		}
+	}
+}
+
+func parseModuleRule(fields []string) (ModuleRule, error) {
+	rule := ModuleRule{Path: fields[0]}
+	for _, field := range fields[1:] {
+		var constraint VersionConstraint
+		for _, op := range []string{"!=", "<=", ">=", "=", "<", ">"} {
+			if version, ok := strings.CutPrefix(field, op); ok {
+				constraint = VersionConstraint{Op: op, Version: version}
+				break
+			}
+		}
+		if constraint.Op == "" || !semver.IsValid(constraint.Version) {
+			return ModuleRule{}, fmt.Errorf("invalid version constraint %q for %s", field, rule.Path)
+		}
+		rule.Constraints = append(rule.Constraints, constraint)
+	}
+	return rule, nil
+}
+
+// checkModule returns an error when the policy does not permit version of the
+// module at modPath. An empty version only checks the path.
+func (policy *Policy) checkModule(modPath, version string) error {
+	i := slices.IndexFunc(policy.Modules, func(rule ModuleRule) bool { return rule.Path == modPath })
+	if i < 0 {
+		return fmt.Errorf("module %s not permitted", modPath)
+	}
+	if version == "" {
+		return nil
+	}
+	for _, constraint := range policy.Modules[i].Constraints {
+		if !constraint.allows(version) {
+			return fmt.Errorf("module %s@%s not permitted, versions must be %s", modPath, version, constraint)
+		}
+	}
+	return nil
+}
+
+// Check returns the modules declared by go.mod files in archive and an error
+// joining a PolicyViolation for every rule the archive breaks. Modules in the
+// archive may require, replace, and import each other when localModules finds
+// they are built from the archive.
+func (policy *Policy) Check(archive *txtar.Archive) ([]Module, error) {
+	var (
+		modules    []Module
+		violations []error
+	)
+	moduleDirs := make(map[string]string)
+	for _, file := range archive.Files {
+		if path.Base(file.Name) != "go.mod" {
+			continue
+		}
+		mod, err := newModule(file)
+		if err != nil {
+			violations = append(violations, err)
+			continue
+		}
+		modules = append(modules, mod)
+		moduleDirs[path.Dir(file.Name)] = mod.Path()
+	}
+	localModulePaths := localModules(archive, modules, moduleDirs)
+	for _, mod := range modules {
+		violations = append(violations, policy.checkRequirements(mod, localModulePaths)...)
+		violations = append(violations, checkReplace(mod.File.Name, mod.Module.Replace, moduleDirs)...)
+	}
+	for _, file := range archive.Files {
+		switch {
+		case path.Base(file.Name) == "go.work":
+			violations = append(violations, checkWorkspace(file, moduleDirs)...)
+		case path.Ext(file.Name) == ".go":
+			fileModules := localModulePaths
+			if modPath, ok := enclosingModule(file.Name, moduleDirs); ok {
+				fileModules = append(slices.Clip(fileModules), modPath)
+			}
+			violations = append(violations, policy.checkGoFile(file, fileModules)...)
+		}
+	}
+	return modules, errors.Join(violations...)
+}
+
+// localModules returns the paths of the modules in the archive the go command
+// builds from the archive rather than downloads: the main modules, which are
+// the modules used by the go.work file at the root or else the root module,
+// and the modules the replace directives of the main modules and of the root
+// go.work file point to. Any other go.mod in the archive is ignored by the go
+// command, so its module path must not exempt a requirement from the policy.
+func localModules(archive *txtar.Archive, modules []Module, moduleDirs map[string]string) []string {
+	var (
+		paths    []string
+		mainDirs []string
+	)
+	addReplaced := func(name string, replacements []*modfile.Replace) {
+		for _, replace := range replacements {
+			if len(checkReplace(name, []*modfile.Replace{replace}, moduleDirs)) == 0 {
+				paths = append(paths, replace.Old.Path)
+			}
+		}
+	}
+	if i := slices.IndexFunc(archive.Files, func(file txtar.File) bool { return file.Name == "go.work" }); i >= 0 {
+		if work, err := modfile.ParseWork("go.work", archive.Files[i].Data, nil); err == nil {
+			for _, use := range work.Use {
+				mainDirs = append(mainDirs, path.Clean(use.Path))
+			}
+			addReplaced("go.work", work.Replace)
+		}
+	} else {
+		mainDirs = append(mainDirs, ".")
+	}
+	for _, mod := range modules {
+		dir := path.Dir(mod.File.Name)
+		if slices.Contains(mainDirs, dir) {
+			paths = append(paths, mod.Path())
+			addReplaced(mod.File.Name, mod.Module.Replace)
+		}
+	}
+	return paths
+}
+
+// enclosingModule returns the path of the module in moduleDirs holding the
+// file name.
+func enclosingModule(name string, moduleDirs map[string]string) (string, bool) {
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if modPath, ok := moduleDirs[dir]; ok {
+			return modPath, true
+		}
+		if dir == "." {
+			return "", false
+		}
+	}
+}
+
+// checkRequirements returns a violation for each requirement, direct or
+// indirect, that is neither permitted nor one of localModules.
+func (policy *Policy) checkRequirements(mod Module, localModules []string) []error {
+	var violations []error
+	for _, requirement := range mod.Module.Require {
+		if slices.Contains(localModules, requirement.Mod.Path) {
+			continue
+		}
+		if err := policy.checkModule(requirement.Mod.Path, requirement.Mod.Version); err != nil {
+			violations = append(violations, &PolicyViolation{File: mod.File.Name, Line: requirement.Syntax.Start.Line, Message: err.Error()})
+		}
+	}
+	return violations
+}
+
+// checkReplace returns a violation for each replacement that is not a
+// relative path to the directory of the module it replaces. name is the
+// go.mod or go.work file holding the directives and moduleDirs maps module
+// directories in the archive to module paths.
+func checkReplace(name string, replacements []*modfile.Replace, moduleDirs map[string]string) []error {
+	var violations []error
+	for _, replace := range replacements {
+		violation := &PolicyViolation{File: name, Line: replace.Syntax.Start.Line}
+		target := replace.New.Path
+		modPath, ok := moduleDirs[path.Join(path.Dir(name), target)]
+		switch {
+		case replace.New.Version != "" || !(strings.HasPrefix(target, "./") || strings.HasPrefix(target, "../")):
+			violation.Message = fmt.Sprintf("replace directive for %s must point to a module directory in the project", replace.Old.Path)
+		case !ok:
+			violation.Message = fmt.Sprintf("replace directive for %s points to %s which is not a module in the project", replace.Old.Path, target)
+		case modPath != replace.Old.Path:
+			violation.Message = fmt.Sprintf("replace directive for %s points to module %s", replace.Old.Path, modPath)
+		default:
+			continue
+		}
+		violations = append(violations, violation)
+	}
+	return violations
+}
+
+// checkWorkspace returns a violation for each use directive in a go.work file
+// naming a directory that is not a module in the archive and for each replace
+// directive checkReplace rejects.
+func checkWorkspace(file txtar.File, moduleDirs map[string]string) []error {
+	work, err := modfile.ParseWork(file.Name, file.Data, nil)
+	if err != nil {
+		return []error{err}
+	}
+	var violations []error
+	for _, use := range work.Use {
+		if _, ok := moduleDirs[path.Join(path.Dir(file.Name), use.Path)]; !ok {
+			violations = append(violations, &PolicyViolation{File: file.Name, Line: use.Syntax.Start.Line, Message: fmt.Sprintf("use directive %s is not a module in the project", use.Path)})
+		}
+	}
+	return append(violations, checkReplace(file.Name, work.Replace, moduleDirs)...)
+}
+
+// checkGoFile returns a violation for each forbidden source construct in
+// file and each import of a package that is neither permitted nor provided by
+// a permitted module or one of localModules. A package belongs to a permitted
+// module when its path starts with the module path and a slash, and to a local
+// module when its path is also the module path.
+func (policy *Policy) checkGoFile(file txtar.File, localModules []string) []error {
+	var violations []*PolicyViolation
+	violate := func(line int, message string) {
+		violations = append(violations, &PolicyViolation{File: file.Name, Line: line, Message: message})
+	}
+	forbids := func(rule string) bool { return slices.Contains(policy.Forbidden, rule) }
+
+	var fileSet token.FileSet
+	f, err := parser.ParseFile(&fileSet, file.Name, file.Data, parser.ParseComments)
+	if err != nil {
+		return []error{err}
+	}
+	for _, group := range f.Comments {
+		for _, comment := range group.List {
+			line := fileSet.Position(comment.Pos()).Line
+			switch {
+			case strings.HasPrefix(comment.Text, "//go:linkname") && forbids(sourceRuleLinkname):
+				violate(line, "//go:linkname directives are not permitted")
+			case strings.HasPrefix(comment.Text, "//go:generate") && forbids(sourceRuleGenerate):
+				violate(line, "//go:generate directives are not permitted")
+			}
+		}
+	}
+	for _, spec := range f.Imports {
+		line := fileSet.Position(spec.Pos()).Line
+		pkgPath, _ := strconv.Unquote(spec.Path.Value)
+		switch {
+		case pkgPath == "C" && forbids(sourceRuleCgo):
+			violate(line, "cgo is not permitted")
+		case pkgPath == "unsafe" && forbids(sourceRuleUnsafe):
+			violate(line, "package unsafe is not permitted")
+		case slices.Contains(policy.Packages, pkgPath):
+		case slices.ContainsFunc(localModules, func(modPath string) bool {
+			return pkgPath == modPath || strings.HasPrefix(pkgPath, modPath+"/")
+		}):
+		case slices.ContainsFunc(policy.Modules, func(rule ModuleRule) bool {
+			return pkgPath == rule.Path || strings.HasPrefix(pkgPath, rule.Path+"/")
+		}):
+		default:
+			violate(line, fmt.Sprintf("package %q not permitted", pkgPath))
+		}
+	}
+	slices.SortStableFunc(violations, func(a, b *PolicyViolation) int { return a.Line - b.Line })
+	errs := make([]error, 0, len(violations))
+	for _, violation := range violations {
+		errs = append(errs, violation)
+	}
+	return errs
+}
+
+// checkModuleGraph lists the modules providing packages to the build of the
+// packages matching patterns, including those only required indirectly, and
+// returns an error joining a PolicyViolation for each one the current policy
+// does not permit. The variables in override, like GOOS, are set in the
+// environment so build constraints match the build. Main modules and modules
+// replaced by a directory have no version and are checked by Policy.Check
+// instead.
+func (dir *FilesystemDirectory) checkModuleGraph(ctx context.Context, goEnv goEnvironment, override []string, goExecPath string, patterns ...string) error {
+	var out bytes.Buffer
+	dir.Output.Reset()
+	args := append([]string{"list", "-deps", "-f", "{{with .Module}}{{with .Replace}}{{.Path}} {{.Version}}{{else}}{{.Path}} {{.Version}}{{end}}{{end}}"}, patterns...)
+	if err := dir.runGo(ctx, goEnv, override, &out, goExecPath, args...); err != nil {
+		return errors.New(dir.Output.String())
+	}
+	policy := currentPolicy()
+	var (
+		violations []error
+		seen       = make(map[string]bool)
+	)
+	for _, line := range strings.Split(out.String(), "\n") {
+		modPath, version, _ := strings.Cut(line, " ")
+		if version == "" || seen[line] {
+			continue
+		}
+		seen[line] = true
+		if err := policy.checkModule(modPath, version); err != nil {
+			violations = append(violations, &PolicyViolation{File: "go.mod", Message: err.Error() + " (required by the module graph)"})
+		}
+	}
+	return errors.Join(violations...)
+}
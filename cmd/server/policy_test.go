@@ -0,0 +1,314 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+func Test_parsePolicy(t *testing.T) {
+	policy, err := parsePolicy("fmt\n\n# comment\nstrings\n", "example.com/a >=v1.2.0 <v2 !=v1.3.0\nexample.com/b\n", "forbid linkname\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path, version string
+		ok            bool
+	}{
+		{path: "example.com/a", version: "v1.2.0", ok: true},
+		{path: "example.com/a", version: "v1.9.9-0.20240101000000-abcdefabcdef", ok: true},
+		{path: "example.com/a", version: "v1.1.9"},
+		{path: "example.com/a", version: "v1.3.0"},
+		{path: "example.com/a", version: "v2.0.0"},
+		{path: "example.com/b", version: "v0.0.1", ok: true},
+		{path: "example.com/c", version: "v1.0.0"},
+	} {
+		if err := policy.checkModule(tt.path, tt.version); (err == nil) != tt.ok {
+			t.Errorf("checkModule(%q, %q) = %v", tt.path, tt.version, err)
+		}
+	}
+
+	_, err = parsePolicy("fmt strings\n", "example.com/a ~v1\n", "forbid goto\n")
+	for _, want := range []string{
+		"import_allow_list.txt:1:",
+		"module_allow_list.txt:1: invalid version constraint",
+		"source_policy.txt:1:",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error containing %q, got %v", want, err)
+		}
+	}
+}
+
+func Test_embeddedPolicy(t *testing.T) {
+	policy, err := parsePolicy(permittedPackagesString, permittedModulesString, sourcePolicyString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Packages) == 0 || len(policy.Modules) == 0 {
+		t.Error("expected packages and modules to be permitted")
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	policy, err := parsePolicy("fmt\n", "example.com/a <v1.5.0\nexample.com/b\n", "forbid linkname\nforbid unsafe\nforbid cgo\nforbid generate\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := txtar.Parse([]byte(`-- go.mod --
+module example.com/app
+
+require example.com/a v1.6.0
+
+require example.com/c v1.0.0 // indirect
+-- main.go --
+package main
+
+//go:generate echo hello
+
+import (
+	"fmt"
+	"unsafe"
+	"C"
+	"os"
+)
+
+//go:linkname now runtime.nanotime
+func now() int64
+
+func main() { fmt.Println(unsafe.Sizeof(0), os.Args) }
+`))
+	_, err = policy.Check(archive)
+	if err == nil {
+		t.Fatal("expected violations")
+	}
+	for _, want := range []string{
+		"go.mod:3: module example.com/a@v1.6.0 not permitted, versions must be <v1.5.0",
+		"go.mod:5: module example.com/c not permitted",
+		"main.go:3: //go:generate directives are not permitted",
+		"main.go:7: package unsafe is not permitted",
+		"main.go:8: cgo is not permitted",
+		`main.go:9: package "os" not permitted`,
+		"main.go:12: //go:linkname directives are not permitted",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected violation %q in:\n%s", want, err)
+		}
+	}
+
+	_, err = policy.Check(txtar.Parse([]byte("-- go.mod --\nmodule example.com/app\n-- main.go --\npackage main\n\nconst usage = `\n//go:linkname is not a directive in a string\n`\n")))
+	if err != nil {
+		t.Errorf("expected directives in string literals to be ignored, got %v", err)
+	}
+}
+
+func TestFilesystemDirectory_checkModuleGraph(t *testing.T) {
+	goExecPath, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not found")
+	}
+	dir, err := newFilesystemDirectory(MemoryDirectory{Archive: txtar.Parse([]byte(`-- go.mod --
+module example.com/app
+
+go 1.25
+
+require example.com/lib v0.0.0
+
+replace example.com/lib => ./lib
+-- main.go --
+package main
+
+import "example.com/lib"
+
+func main() { lib.F() }
+-- lib/go.mod --
+module example.com/lib
+
+go 1.25
+-- lib/lib.go --
+package lib
+
+func F() {}
+`))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = dir.close() }()
+
+	if err := dir.checkModuleGraph(t.Context(), goEnvironment{}, goEnvOverride(), goExecPath, "./..."); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPolicy_Check_localModules(t *testing.T) {
+	policy, err := parsePolicy("fmt\n", "example.com/a\n", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		archive string
+		wantErr []string
+	}{
+		{
+			name: "stub module",
+			archive: `-- go.mod --
+module example.com/app
+
+require github.com/evil/x v1.0.0
+-- main.go --
+package main
+
+import "github.com/evil/x"
+
+func main() { x.F() }
+-- x/go.mod --
+module github.com/evil/x
+-- x/x.go --
+package x
+
+import "github.com/evil/x/internal"
+
+func F() { internal.F() }
+`,
+			wantErr: []string{
+				"go.mod:3: module github.com/evil/x not permitted",
+				`main.go:3: package "github.com/evil/x" not permitted`,
+			},
+		},
+		{
+			name: "replace",
+			archive: `-- go.mod --
+module example.com/app
+
+require example.com/lib v0.0.0
+
+replace example.com/lib => ./lib
+-- main.go --
+package main
+
+import "example.com/lib"
+
+func main() { lib.F() }
+-- lib/go.mod --
+module example.com/lib
+-- lib/lib.go --
+package lib
+
+func F() {}
+`,
+		},
+		{
+			name: "workspace",
+			archive: `-- go.work --
+go 1.25
+
+use (
+	./app
+	./lib
+)
+-- app/go.mod --
+module example.com/app
+
+require example.com/lib v0.0.0
+-- app/main.go --
+package main
+
+import "example.com/lib"
+
+func main() { lib.F() }
+-- lib/go.mod --
+module example.com/lib
+-- lib/lib.go --
+package lib
+
+func F() {}
+`,
+		},
+		{
+			name: "replace outside the main module",
+			archive: `-- go.mod --
+module example.com/app
+
+require github.com/evil/x v1.0.0
+-- other/go.mod --
+module example.com/other
+
+replace github.com/evil/x => ../x
+-- x/go.mod --
+module github.com/evil/x
+`,
+			wantErr: []string{"go.mod:3: module github.com/evil/x not permitted"},
+		},
+		{
+			name: "permitted module root",
+			archive: `-- go.mod --
+module example.com/app
+-- main.go --
+package main
+
+import "example.com/a"
+`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := policy.Check(txtar.Parse([]byte(tt.archive)))
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("expected violation %q in:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestFilesystemDirectory_build_moduleGraph(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping build in short mode")
+	}
+	goExecPath, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not found")
+	}
+	cache := t.TempDir()
+	writeModuleCache(t, cache, "github.com/crhntr/dom", "v0.1.0", map[string]string{
+		"go.mod":       "module github.com/crhntr/dom\n\ngo 1.25\n\nrequire example.com/secret v1.0.0\n",
+		"html/html.go": "package html\n\nimport \"example.com/secret\"\n\nfunc F() { secret.F() }\n",
+	})
+	writeModuleCache(t, cache, "example.com/secret", "v1.0.0", map[string]string{
+		"go.mod":    "module example.com/secret\n\ngo 1.25\n",
+		"secret.go": "package secret\n\nfunc F() {}\n",
+	})
+	goEnv := goEnvironment{moduleProxy: moduleProxyEnv("file://" + filepath.ToSlash(moduleCacheProxy{dir: cache}.downloadDir()))}
+
+	// The go.mod file leaves out the indirect requirement the go command
+	// adds while building.
+	dir, err := newFilesystemDirectory(MemoryDirectory{Archive: txtar.Parse([]byte(`-- go.mod --
+module example.com/app
+
+go 1.25
+
+require github.com/crhntr/dom v0.1.0
+-- main.go --
+package main
+
+import "github.com/crhntr/dom/html"
+
+func main() { html.F() }
+`))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = dir.close() }()
+
+	_, err = dir.buildWASM(t.Context(), goEnv, goExecPath)
+	if err == nil || !strings.Contains(err.Error(), "module example.com/secret not permitted") {
+		t.Errorf("expected the indirect requirement to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// policyReloadInterval is how often policy files are checked for changes.
+const policyReloadInterval = 10 * time.Second
+
+// activePolicy holds the policy loaded by watchPolicy. When it is nil the
+// embedded policy is used.
+var activePolicy atomic.Pointer[Policy]
+
+// currentPolicy returns the policy projects are checked against.
+func currentPolicy() *Policy {
+	if policy := activePolicy.Load(); policy != nil {
+		return policy
+	}
+	return embeddedPolicy()
+}
+
+// policyFiles names the files a Policy is read from. A file left empty, or
+// missing from Dir, uses the embedded default.
+type policyFiles struct {
+	// Dir may hold import_allow_list.txt, module_allow_list.txt, and
+	// source_policy.txt.
+	Dir      string
+	Packages string
+	Modules  string
+	Source   string
+}
+
+func (files policyFiles) isZero() bool { return files == policyFiles{} }
+
+// paths returns the package, module, and source policy paths. Paths set
+// explicitly take precedence over those in Dir.
+func (files policyFiles) paths() [3]string {
+	paths := [3]string{files.Packages, files.Modules, files.Source}
+	if files.Dir != "" {
+		for i, name := range []string{packagePolicyFile, modulePolicyFile, sourcePolicyFile} {
+			if paths[i] == "" {
+				paths[i] = filepath.Join(files.Dir, name)
+			}
+		}
+	}
+	return paths
+}
+
+// load reads and parses the policy files.
+func (files policyFiles) load() (*Policy, error) {
+	contents := [3]string{permittedPackagesString, permittedModulesString, sourcePolicyString}
+	for i, p := range files.paths() {
+		if p == "" {
+			continue
+		}
+		buf, err := os.ReadFile(p)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && files.Dir != "" && filepath.Dir(p) == filepath.Clean(files.Dir) {
+				continue
+			}
+			return nil, err
+		}
+		contents[i] = string(buf)
+	}
+	return parsePolicy(contents[0], contents[1], contents[2])
+}
+
+// version summarizes the size and modification time of the policy files so
+// changes can be detected without reading them.
+func (files policyFiles) version() string {
+	var sb strings.Builder
+	for _, p := range files.paths() {
+		if p == "" {
+			continue
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			fmt.Fprintf(&sb, "%s: %v\n", p, err)
+			continue
+		}
+		fmt.Fprintf(&sb, "%s: %d %d\n", p, info.Size(), info.ModTime().UnixNano())
+	}
+	return sb.String()
+}
+
+// watchPolicy loads the policy from files and then checks the files for
+// changes every interval until ctx is done. A policy that fails to load at
+// startup is returned as an error. Later failures are logged and the last
+// policy that loaded is kept.
+func watchPolicy(ctx context.Context, files policyFiles, interval time.Duration) error {
+	if files.isZero() {
+		return nil
+	}
+	version := files.version()
+	policy, err := files.load()
+	if err != nil {
+		return fmt.Errorf("failed to load policy: %w", err)
+	}
+	activePolicy.Store(policy)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			next := files.version()
+			if next == version {
+				continue
+			}
+			version = next
+			policy, err := files.load()
+			if err != nil {
+				log.Println("failed to reload policy, keeping the previous policy:", err)
+				continue
+			}
+			activePolicy.Store(policy)
+			log.Println("reloaded policy")
+		}
+	}()
+	return nil
+}
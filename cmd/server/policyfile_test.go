@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_watchPolicy(t *testing.T) {
+	t.Cleanup(func() { activePolicy.Store(nil) })
+
+	dir := t.TempDir()
+	modules := filepath.Join(dir, modulePolicyFile)
+	writeFile := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(modules, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// Set the time explicitly since writes in quick succession may share
+		// a modification time.
+		if err := os.Chtimes(modules, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	permits := func(modPath string) bool {
+		return slices.ContainsFunc(currentPolicy().Modules, func(rule ModuleRule) bool { return rule.Path == modPath })
+	}
+	waitFor := func(condition func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !condition() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for policy reload")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	now := time.Now()
+	writeFile("example.com/a\n", now)
+	if err := watchPolicy(t.Context(), policyFiles{Dir: dir}, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if !permits("example.com/a") {
+		t.Fatal("expected the module allow list to be loaded from the directory")
+	}
+	if len(currentPolicy().Packages) == 0 {
+		t.Error("expected files missing from the directory to use the embedded policy")
+	}
+
+	writeFile("example.com/b\n", now.Add(time.Second))
+	waitFor(func() bool { return permits("example.com/b") })
+
+	writeFile("example.com/c ~v1\n", now.Add(2*time.Second))
+	time.Sleep(50 * time.Millisecond)
+	if !permits("example.com/b") || permits("example.com/c") {
+		t.Error("expected an invalid policy to keep the last good policy")
+	}
+}
+
+func Test_watchPolicy_invalid(t *testing.T) {
+	t.Cleanup(func() { activePolicy.Store(nil) })
+
+	source := filepath.Join(t.TempDir(), "source.txt")
+	if err := os.WriteFile(source, []byte("forbid everything\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := watchPolicy(t.Context(), policyFiles{Source: source}, time.Second); err == nil {
+		t.Fatal("expected an invalid policy to fail at startup")
+	}
+	if err := watchPolicy(t.Context(), policyFiles{Modules: filepath.Join(t.TempDir(), "missing.txt")}, time.Second); err == nil {
+		t.Fatal("expected a missing file to fail at startup")
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// routeClass names a group of routes that share a rate limit budget.
+type routeClass string
+
+const (
+	// routeClassBuild routes run the go command.
+	routeClassBuild routeClass = "build"
+	// routeClassEdit routes only transform the submitted project.
+	routeClassEdit routeClass = "edit"
+	// routeClassImport routes load projects from uploads or other services.
+	routeClassImport routeClass = "import"
+)
+
+const (
+	defaultBuildQuota = time.Hour
+	// clientIdleTimeout is how long the buckets of an idle client are kept.
+	// Its build time is kept until the end of the day.
+	clientIdleTimeout = time.Hour
+)
+
+// rateBudgets holds the token bucket budget of each route class. It is a
+// flag.Value set with a comma separated list of "CLASS=N/DURATION", like
+// "build=30/1m".
+type rateBudgets map[routeClass]rateBudget
+
+// rateBudget is a token bucket budget: N requests may be made at once, and the
+// bucket refills at N per DURATION. It is a flag.Value set with "N/DURATION".
+type rateBudget struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (budget rateBudget) String() string {
+	return fmt.Sprintf("%d/%s", budget.Requests, budget.Per)
+}
+
+func (budget *rateBudget) Set(value string) error {
+	requests, per, ok := strings.Cut(value, "/")
+	if !ok {
+		return fmt.Errorf("rate limit %q must have the form N/DURATION", value)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("rate limit %q must allow a positive number of requests", value)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("rate limit %q must have a positive duration", value)
+	}
+	*budget = rateBudget{Requests: n, Per: d}
+	return nil
+}
+
+// limiter returns a token bucket with the budget.
+func (budget rateBudget) limiter() *rate.Limiter {
+	return rate.NewLimiter(rate.Every(budget.Per/time.Duration(budget.Requests)), budget.Requests)
+}
+
+func defaultRateBudgets() rateBudgets {
+	return rateBudgets{
+		routeClassBuild:  {Requests: 30, Per: time.Minute},
+		routeClassEdit:   {Requests: 120, Per: time.Minute},
+		routeClassImport: {Requests: 30, Per: time.Minute},
+	}
+}
+
+func (budgets rateBudgets) String() string {
+	entries := make([]string, 0, len(budgets))
+	for class, budget := range budgets {
+		entries = append(entries, fmt.Sprintf("%s=%s", class, budget))
+	}
+	slices.Sort(entries)
+	return strings.Join(entries, ",")
+}
+
+func (budgets rateBudgets) Set(value string) error {
+	for entry := range strings.SplitSeq(value, ",") {
+		class, budgetString, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return fmt.Errorf("rate limit %q must have the form CLASS=N/DURATION", entry)
+		}
+		switch routeClass(class) {
+		case routeClassBuild, routeClassEdit, routeClassImport:
+		default:
+			return fmt.Errorf("unknown route class %q, use %s, %s, or %s", class, routeClassBuild, routeClassEdit, routeClassImport)
+		}
+		var budget rateBudget
+		if err := budget.Set(budgetString); err != nil {
+			return err
+		}
+		budgets[routeClass(class)] = budget
+	}
+	return nil
+}
+
+// clientLimiter rate limits each client of the server. Clients are the
+// authenticated user, or else the address in the trusted proxy header, or else
+// the remote address. Each client has a token bucket per route class and a
+// daily quota of time spent building, see startBuild.
+type clientLimiter struct {
+	budgets rateBudgets
+	// buildQuota is the time each client may spend building each UTC day.
+	// Zero means no quota.
+	buildQuota time.Duration
+	// trustedHeader, like "X-Forwarded-For", is set by a proxy in front of
+	// the server. It must not be set when clients connect directly, since
+	// they could then pick their own key.
+	trustedHeader string
+	now           func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*clientUsage
+	lastSweep time.Time
+}
+
+type clientUsage struct {
+	buckets   map[routeClass]*rate.Limiter
+	day       string
+	buildTime time.Duration
+	// reserved is the build time held by the client's running builds.
+	reserved time.Duration
+	lastSeen time.Time
+}
+
+func newClientLimiter(budgets rateBudgets, buildQuota time.Duration, trustedHeader string) *clientLimiter {
+	return &clientLimiter{
+		budgets:       budgets,
+		buildQuota:    buildQuota,
+		trustedHeader: trustedHeader,
+		now:           time.Now,
+		clients:       make(map[string]*clientUsage),
+	}
+}
+
+// clientKey identifies the client making req.
+func (limiter *clientLimiter) clientKey(req *http.Request) string {
+	if user := userFromContext(req.Context()); user != "" {
+		return "user:" + user
+	}
+	return "ip:" + clientAddress(req, limiter.trustedHeader)
+}
+
+// clientAddress returns the address of the client making req: the one in
+// trustedHeader, when it is set and present, or else the remote address.
+func clientAddress(req *http.Request, trustedHeader string) string {
+	if trustedHeader != "" {
+		// Proxies append to headers like X-Forwarded-For, so the last
+		// address is the one the trusted proxy added.
+		values := strings.Split(req.Header.Get(trustedHeader), ",")
+		if addr := strings.TrimSpace(values[len(values)-1]); addr != "" {
+			return addr
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// client returns the usage of key, resetting the build time on a new day. It
+// must be called with limiter.mu held.
+func (limiter *clientLimiter) client(key string, now time.Time) *clientUsage {
+	today := now.UTC().Format(time.DateOnly)
+	if now.Sub(limiter.lastSweep) > clientIdleTimeout {
+		for k, c := range limiter.clients {
+			if now.Sub(c.lastSeen) > clientIdleTimeout && c.reserved == 0 && (c.buildTime == 0 || c.day != today) {
+				delete(limiter.clients, k)
+			}
+		}
+		limiter.lastSweep = now
+	}
+	c, ok := limiter.clients[key]
+	if !ok {
+		c = &clientUsage{buckets: make(map[routeClass]*rate.Limiter)}
+		limiter.clients[key] = c
+	}
+	if c.day != today {
+		c.day, c.buildTime = today, 0
+	}
+	c.lastSeen = now
+	return c
+}
+
+// reserve takes a token from the bucket of class for key. When the bucket is
+// empty or the build quota is used up it returns how long the client should
+// wait and a message saying which limit it hit.
+func (limiter *clientLimiter) reserve(key string, class routeClass) (time.Duration, string) {
+	now := limiter.now()
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	c := limiter.client(key, now)
+	if class == routeClassBuild {
+		if err := limiter.checkBuildQuota(c, now); err != nil {
+			return err.retryAfter, err.Error()
+		}
+	}
+	budget, ok := limiter.budgets[class]
+	if !ok {
+		return 0, ""
+	}
+	bucket, ok := c.buckets[class]
+	if !ok {
+		bucket = budget.limiter()
+		c.buckets[class] = bucket
+	}
+	reservation := bucket.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return delay, fmt.Sprintf("rate limit of %d %s requests per %s exceeded", budget.Requests, class, budget.Per)
+	}
+	return 0, ""
+}
+
+// buildQuotaError is returned when a client has used up its build quota.
+type buildQuotaError struct {
+	quota      time.Duration
+	retryAfter time.Duration
+}
+
+func (err *buildQuotaError) Error() string {
+	return fmt.Sprintf("daily build time quota of %s used up", err.quota)
+}
+
+// checkBuildQuota returns an error when the build time of c, along with the
+// time reserved by its running builds, has used up the quota. It must be
+// called with limiter.mu held.
+func (limiter *clientLimiter) checkBuildQuota(c *clientUsage, now time.Time) *buildQuotaError {
+	if limiter.buildQuota <= 0 || c.buildTime+c.reserved < limiter.buildQuota {
+		return nil
+	}
+	midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	return &buildQuotaError{quota: limiter.buildQuota, retryAfter: midnight.Sub(now)}
+}
+
+type buildMeterKey struct{}
+
+// buildMeter is put in the context of requests to build routes by
+// clientLimiter.limit so startBuild knows the client to charge.
+type buildMeter struct {
+	limiter *clientLimiter
+	key     string
+}
+
+// startBuild charges a build that is about to start to the client making the
+// request of ctx. It returns a *buildQuotaError when the client's build quota
+// is used up. Until the returned function is called, the time left before the
+// deadline of ctx is reserved from the quota, so concurrent builds can not all
+// start with the last of it. The function adds the time since the build
+// started to the client's build time.
+func startBuild(ctx context.Context) (func(), error) {
+	meter, ok := ctx.Value(buildMeterKey{}).(buildMeter)
+	if !ok {
+		return func() {}, nil
+	}
+	limiter := meter.limiter
+	var reserved time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		reserved = max(time.Until(deadline), 0)
+	}
+	start := limiter.now()
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	c := limiter.client(meter.key, start)
+	if err := limiter.checkBuildQuota(c, start); err != nil {
+		return nil, err
+	}
+	c.reserved += reserved
+	return func() {
+		end := limiter.now()
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		c := limiter.client(meter.key, end)
+		c.reserved -= reserved
+		c.buildTime += end.Sub(start)
+	}, nil
+}
+
+// limit rate limits next as a route of class. Requests to build routes may
+// call startBuild to charge their build time to the client. Responses tell
+// htmx to refresh the usage shown in the editor.
+func (limiter *clientLimiter) limit(class routeClass, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		key := limiter.clientKey(req)
+		if retryAfter, msg := limiter.reserve(key, class); retryAfter > 0 {
+			writeTooManyRequests(res, retryAfter, msg)
+			return
+		}
+		res.Header().Set("HX-Trigger", "usage-changed")
+		if class == routeClassBuild {
+			req = req.WithContext(context.WithValue(req.Context(), buildMeterKey{}, buildMeter{limiter: limiter, key: key}))
+		}
+		next.ServeHTTP(res, req)
+	})
+}
+
+// writeTooManyRequests responds with msg and how long the client should wait
+// before retrying.
+func writeTooManyRequests(res http.ResponseWriter, retryAfter time.Duration, msg string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	res.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(res, fmt.Sprintf("%s, try again in %s", msg, time.Duration(seconds)*time.Second), http.StatusTooManyRequests)
+}
+
+// Usage is the rate limit state of a client shown in the editor.
+type Usage struct {
+	BuildTime  time.Duration
+	BuildQuota time.Duration
+	// Builds is the number of build requests the client may make now.
+	Builds int
+}
+
+func (usage Usage) BuildTimeString() string  { return usage.BuildTime.Round(time.Second).String() }
+func (usage Usage) BuildQuotaString() string { return usage.BuildQuota.String() }
+
+func (limiter *clientLimiter) usage(key string) Usage {
+	now := limiter.now()
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	c := limiter.client(key, now)
+	usage := Usage{BuildTime: c.buildTime, BuildQuota: limiter.buildQuota, Builds: -1}
+	if budget, ok := limiter.budgets[routeClassBuild]; ok {
+		usage.Builds = budget.Requests
+		if bucket, ok := c.buckets[routeClassBuild]; ok {
+			usage.Builds = int(bucket.TokensAt(now))
+		}
+	}
+	return usage
+}
+
+func handleUsage(limiter *clientLimiter) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		usage := limiter.usage(limiter.clientKey(req))
+		renderHTML(res, req, http.StatusOK, func(w io.Writer) error {
+			return templates.ExecuteTemplate(w, "usage", usage)
+		})
+	}
+}
+
+type userContextKey struct{}
+
+// contextWithUser returns a context holding the name of the authenticated
+// user making a request.
+func contextWithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// userFromContext returns the authenticated user set by contextWithUser, or
+// "" for anonymous requests.
+func userFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userContextKey{}).(string)
+	return user
+}
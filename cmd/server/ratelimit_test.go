@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_clientLimiter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 23, 0, 0, 0, time.UTC)
+	limiter := newClientLimiter(rateBudgets{
+		routeClassBuild: {Requests: 2, Per: time.Minute},
+	}, 30*time.Minute, "X-Forwarded-For")
+	limiter.now = func() time.Time { return now }
+
+	var buildDuration, queueDuration time.Duration
+	queue := newBuildQueue(1)
+	build := limiter.limit(routeClassBuild, http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		now = now.Add(queueDuration)
+		release, err := queue.acquire(req.Context())
+		if err != nil {
+			writeAcquireError(res, err)
+			return
+		}
+		defer release()
+		now = now.Add(buildDuration)
+	}))
+	edit := limiter.limit(routeClassEdit, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	request := func(h http.Handler, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/go/run", nil)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for range 2 {
+		if rec := request(build, "203.0.113.1, 198.51.100.7"); rec.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+	rec := request(build, "203.0.113.1, 198.51.100.7")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("got Retry-After %q, want 30", got)
+	}
+	if !strings.Contains(rec.Body.String(), "rate limit of 2 build requests per 1m0s exceeded") {
+		t.Errorf("expected the message to name the limit, got %q", rec.Body.String())
+	}
+	if rec := request(edit, "198.51.100.7"); rec.Code != http.StatusOK {
+		t.Errorf("expected route classes to have separate budgets, got status %d", rec.Code)
+	}
+	if rec := request(build, "198.51.100.8"); rec.Code != http.StatusOK {
+		t.Errorf("expected clients to have separate budgets, got status %d", rec.Code)
+	}
+
+	now = now.Add(time.Minute)
+	buildDuration, queueDuration = 20*time.Minute, 5*time.Minute
+	for range 2 {
+		if rec := request(build, "198.51.100.7"); rec.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+	rec = request(build, "198.51.100.7")
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "daily build time quota of 30m0s used up") {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got, want := rec.Header().Get("Retry-After"), "540"; got != want {
+		t.Errorf("expected a retry at midnight, got Retry-After %q, want %s", got, want)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/usage", nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	usage := limiter.usage(limiter.clientKey(req))
+	if usage.BuildTime != 40*time.Minute || usage.BuildQuota != 30*time.Minute || usage.Builds != 2 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+	rec = httptest.NewRecorder()
+	handleUsage(limiter).ServeHTTP(rec, req)
+	if got := rec.Body.String(); !strings.Contains(got, "Build time today: 40m0s of 30m0s. Builds available: 2.") {
+		t.Errorf("unexpected usage fragment %q", got)
+	}
+
+	now = now.Add(time.Hour)
+	if rec := request(build, "198.51.100.7"); rec.Code != http.StatusOK {
+		t.Errorf("expected the quota to reset the next day, got status %d", rec.Code)
+	}
+}
+
+func Test_startBuild(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	limiter := newClientLimiter(defaultRateBudgets(), 30*time.Minute, "")
+	limiter.now = func() time.Time { return now }
+	ctx, cancel := context.WithTimeout(context.WithValue(t.Context(), buildMeterKey{}, buildMeter{limiter: limiter, key: "ip:192.0.2.1"}), 20*time.Minute)
+	defer cancel()
+
+	endFirst, err := startBuild(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endSecond, err := startBuild(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var quota *buildQuotaError
+	if _, err := startBuild(ctx); !errors.As(err, &quota) {
+		t.Fatalf("expected the time reserved by running builds to use up the quota, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	endFirst()
+	endSecond()
+	if usage := limiter.usage("ip:192.0.2.1"); usage.BuildTime != 2*time.Minute {
+		t.Errorf("got build time %s, want 2m0s", usage.BuildTime)
+	}
+	endThird, err := startBuild(ctx)
+	if err != nil {
+		t.Fatalf("expected finished builds to free their reservation, got %v", err)
+	}
+	endThird()
+}
+
+func Test_clientLimiter_clientKey(t *testing.T) {
+	limiter := newClientLimiter(defaultRateBudgets(), 0, "")
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	if got := limiter.clientKey(req); got != "ip:192.0.2.1" {
+		t.Errorf("expected an untrusted header to be ignored, got %q", got)
+	}
+	req = req.WithContext(contextWithUser(req.Context(), "gopher"))
+	if got := limiter.clientKey(req); got != "user:gopher" {
+		t.Errorf("got %q, want user:gopher", got)
+	}
+}
+
+func Test_rateBudgets_Set(t *testing.T) {
+	budgets := defaultRateBudgets()
+	if err := budgets.Set("build=5/10s"); err != nil {
+		t.Fatal(err)
+	}
+	if got := budgets[routeClassBuild]; got != (rateBudget{Requests: 5, Per: 10 * time.Second}) {
+		t.Errorf("got %+v", got)
+	}
+	for _, value := range []string{"build", "build=5", "deploy=5/1s", "edit=0/1s", "edit=1/0s"} {
+		if err := budgets.Set(value); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/google/go-github/v89/github"
+	"golang.org/x/time/rate"
+	"golang.org/x/tools/txtar"
+)
+
+const (
+	maxRepositoryArchiveBytes = 1 << 24
+	maxRepositoryFiles        = 100
+	maxRepositoryBytes        = 1 << 20
+	// maxRefSegments limits the GitHub API requests resolving a ref that
+	// may contain slashes, like "feature/x".
+	maxRefSegments = 4
+)
+
+var errRepositoryTooLarge = errors.New("repository directory is too large")
+
+// repositorySource imports a directory of a GitHub repository at a ref.
+// References have the form "{owner}/{repo}/tree/{ref}/{path}", where ref
+// may contain slashes like the branch "feature/x".
+type repositorySource struct {
+	client  *github.Client
+	limiter *rate.Limiter
+}
+
+func (source repositorySource) Import(ctx context.Context, ref string) (Project, error) {
+	segments := strings.SplitN(ref, "/", 4)
+	if len(segments) < 4 || segments[0] == "" || segments[1] == "" || segments[2] != "tree" || segments[3] == "" {
+		return Project{}, &importError{status: http.StatusBadRequest, message: "repository references have the form {owner}/{repo}/tree/{ref}/{path}"}
+	}
+	owner, repo := segments[0], segments[1]
+
+	if !source.limiter.Allow() {
+		return Project{}, &importError{status: http.StatusTooManyRequests, message: "rate limit exceeded, try again later"}
+	}
+
+	gitRef, dirPath, err := source.resolveRef(ctx, owner, repo, segments[3])
+	if err != nil {
+		return Project{}, err
+	}
+	link, resp, err := source.client.Repositories.GetArchiveLink(ctx, owner, repo, github.Tarball, &github.RepositoryContentGetOptions{Ref: gitRef}, 1)
+	if err != nil {
+		if resp != nil {
+			switch resp.StatusCode {
+			case http.StatusNotFound:
+				return Project{}, &importError{status: http.StatusNotFound, message: "repository not found"}
+			case http.StatusForbidden:
+				return Project{}, &importError{status: http.StatusTooManyRequests, message: "GitHub API rate limit exceeded"}
+			}
+		}
+		return Project{}, &importError{status: http.StatusBadGateway, message: "failed to fetch repository", err: err}
+	}
+
+	archive, err := downloadRepositoryDirectory(ctx, link.String(), dirPath)
+	if err != nil {
+		if errors.Is(err, errRepositoryTooLarge) {
+			return Project{}, &importError{status: http.StatusRequestEntityTooLarge, message: err.Error()}
+		}
+		return Project{}, &importError{status: http.StatusBadGateway, message: "failed to download repository", err: err}
+	}
+	if len(archive.Files) == 0 {
+		return Project{}, &importError{status: http.StatusNotFound, message: "directory not found"}
+	}
+	archiveFS, err := txtar.FS(archive)
+	if err != nil {
+		return Project{}, err
+	}
+	dir, rejected, err := newMemoryDirectoryFromFS(archiveFS)
+	if err != nil {
+		return Project{}, err
+	}
+	return Project{Name: path.Join(repo, dirPath), Dir: dir, Rejected: rejected}, nil
+}
+
+// resolveRef splits refPath, "{ref}/{path}", into a ref and a directory. As
+// both may contain slashes, the shortest prefix naming a commit is the ref,
+// which is returned as the commit SHA. A refPath without a slash is all ref.
+func (source repositorySource) resolveRef(ctx context.Context, owner, repo, refPath string) (string, string, error) {
+	segments := strings.Split(refPath, "/")
+	if len(segments) == 1 {
+		return refPath, "", nil
+	}
+	for i := 1; i <= min(len(segments), maxRefSegments); i++ {
+		sha, resp, err := source.client.Repositories.GetCommitSHA1(ctx, owner, repo, strings.Join(segments[:i], "/"), "")
+		if err == nil {
+			return sha, path.Clean("/" + strings.Join(segments[i:], "/"))[1:], nil
+		}
+		if resp == nil {
+			return "", "", &importError{status: http.StatusBadGateway, message: "failed to fetch repository", err: err}
+		}
+		switch resp.StatusCode {
+		case http.StatusNotFound, http.StatusUnprocessableEntity:
+			continue
+		case http.StatusForbidden:
+			return "", "", &importError{status: http.StatusTooManyRequests, message: "GitHub API rate limit exceeded"}
+		default:
+			return "", "", &importError{status: http.StatusBadGateway, message: "failed to fetch repository", err: err}
+		}
+	}
+	return "", "", &importError{status: http.StatusNotFound, message: fmt.Sprintf("repository or ref not found, refs may have at most %d path segments", maxRefSegments)}
+}
+
+func downloadRepositoryDirectory(ctx context.Context, archiveURL, dirPath string) (*txtar.Archive, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, archiveURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAndIgnoreError(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status downloading repository archive: %s", res.Status)
+	}
+	errTarballTooLarge := fmt.Errorf("%w: the repository archive is more than %d bytes", errRepositoryTooLarge, maxRepositoryArchiveBytes)
+	if res.ContentLength > maxRepositoryArchiveBytes {
+		return nil, errTarballTooLarge
+	}
+	buf, err := io.ReadAll(io.LimitReader(res.Body, maxRepositoryArchiveBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maxRepositoryArchiveBytes {
+		return nil, errTarballTooLarge
+	}
+	return readRepositoryTarball(bytes.NewReader(buf), dirPath)
+}
+
+// readRepositoryTarball reads the files under dirPath from a GitHub source
+// tarball. GitHub prefixes every entry with a single "{owner}-{repo}-{sha}"
+// directory; it is removed along with dirPath so the returned file names are
+// relative to dirPath. Files that are not permitted are returned without
+// their content, so newMemoryDirectoryFromFS reports them as rejected.
+func readRepositoryTarball(r io.Reader, dirPath string) (*txtar.Archive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAndIgnoreError(gz)
+
+	archive := new(txtar.Archive)
+	files, totalBytes := 0, 0
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return archive, nil
+			}
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		_, name, ok := strings.Cut(header.Name, "/")
+		if !ok {
+			continue
+		}
+		if dirPath != "" {
+			if name, ok = strings.CutPrefix(name, dirPath+"/"); !ok {
+				continue
+			}
+		}
+		if !isPermittedFile(name) {
+			archive.Files = append(archive.Files, txtar.File{Name: name})
+			continue
+		}
+		if files++; files > maxRepositoryFiles {
+			return nil, fmt.Errorf("%w: more than %d files", errRepositoryTooLarge, maxRepositoryFiles)
+		}
+		totalBytes += int(header.Size)
+		if totalBytes > maxRepositoryBytes {
+			return nil, fmt.Errorf("%w: more than %d bytes", errRepositoryTooLarge, maxRepositoryBytes)
+		}
+		buf, err := io.ReadAll(io.LimitReader(tr, header.Size))
+		if err != nil {
+			return nil, err
+		}
+		archive.Files = append(archive.Files, txtar.File{Name: name, Data: buf})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func repositoryTarball(t *testing.T, files map[string]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func Test_readRepositoryTarball(t *testing.T) {
+	files := map[string]string{
+		"owner-repo-abc123/go.mod":                   "module example.com/repo\n",
+		"owner-repo-abc123/README.md":                "# Repo\n",
+		"owner-repo-abc123/examples/hello/go.mod":    "module example.com/hello\n",
+		"owner-repo-abc123/examples/hello/main.go":   "package main\n",
+		"owner-repo-abc123/examples/hello/.env":      "SECRET=1\n",
+		"owner-repo-abc123/examples/hello/a/util.go": "package a\n",
+		"owner-repo-abc123/examples/other/main.go":   "package main\n",
+	}
+
+	t.Run("subdirectory", func(t *testing.T) {
+		archive, err := readRepositoryTarball(repositoryTarball(t, files), "examples/hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range archive.Files {
+			names = append(names, f.Name)
+		}
+		if want := []string{".env", "a/util.go", "go.mod", "main.go"}; !slices.Equal(names, want) {
+			t.Errorf("got files %v, want %v", names, want)
+		}
+	})
+
+	t.Run("root", func(t *testing.T) {
+		archive, err := readRepositoryTarball(repositoryTarball(t, files), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(archive.Files) != 7 {
+			t.Errorf("expected 7 files, got %d", len(archive.Files))
+		}
+		for _, f := range archive.Files {
+			if f.Name == "examples/hello/.env" && f.Data != nil {
+				t.Error("expected the content of a file that is not permitted to be left out")
+			}
+		}
+	})
+
+	t.Run("too many files", func(t *testing.T) {
+		many := make(map[string]string)
+		for i := range maxRepositoryFiles + 1 {
+			many[fmt.Sprintf("owner-repo-abc123/f%03d.go", i)] = "package main\n"
+		}
+		if _, err := readRepositoryTarball(repositoryTarball(t, many), ""); !errors.Is(err, errRepositoryTooLarge) {
+			t.Errorf("expected errRepositoryTooLarge, got %v", err)
+		}
+	})
+}
+
+func Test_downloadRepositoryDirectory_tooLarge(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		// Flushing before writing the body leaves out the Content-Length.
+		res.(http.Flusher).Flush()
+		_, _ = res.Write(make([]byte, maxRepositoryArchiveBytes+1))
+	}))
+	t.Cleanup(srv.Close)
+
+	_, err := downloadRepositoryDirectory(t.Context(), srv.URL, "")
+	if !errors.Is(err, errRepositoryTooLarge) || !strings.Contains(err.Error(), strconv.Itoa(maxRepositoryArchiveBytes)) {
+		t.Errorf("expected errRepositoryTooLarge naming the limit, got %v", err)
+	}
+}
+
+func Test_repositorySource_Import_refWithSlash(t *testing.T) {
+	tarball := repositoryTarball(t, map[string]string{
+		"owner-repo-abc123/examples/hello/go.mod":  "module example.com/hello\n",
+		"owner-repo-abc123/examples/hello/main.go": "package main\n",
+	}).Bytes()
+	api := http.NewServeMux()
+	api.HandleFunc("GET /api/v3/repos/owner/repo/commits/{ref...}", func(res http.ResponseWriter, req *http.Request) {
+		if req.PathValue("ref") != "feature/x" {
+			http.Error(res, "No commit found", http.StatusUnprocessableEntity)
+			return
+		}
+		_, _ = res.Write([]byte("abc123"))
+	})
+	var srvURL string
+	api.HandleFunc("GET /api/v3/repos/owner/repo/tarball/abc123", func(res http.ResponseWriter, req *http.Request) {
+		http.Redirect(res, req, srvURL+"/tarball", http.StatusFound)
+	})
+	api.HandleFunc("GET /tarball", func(res http.ResponseWriter, req *http.Request) {
+		_, _ = res.Write(tarball)
+	})
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	srvURL = srv.URL
+
+	_, client, err := newEnterpriseGitHubClient(srv.URL+"/", srv.URL+"/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := repositorySource{client: client, limiter: rate.NewLimiter(rate.Inf, 1)}
+
+	project, err := source.Import(t.Context(), "owner/repo/tree/feature/x/examples/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.Name != "repo/examples/hello" || len(project.Dir.Archive.Files) != 2 {
+		t.Errorf("expected the directory at the branch feature/x, got %q with %d files", project.Name, len(project.Dir.Archive.Files))
+	}
+
+	var ie *importError
+	if _, err := source.Import(t.Context(), "owner/repo/tree/missing/examples/hello"); !errors.As(err, &ie) || ie.status != http.StatusNotFound {
+		t.Errorf("expected a missing ref to be not found, got %v", err)
+	}
+}
@@ -14,9 +14,11 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -27,6 +29,8 @@ type (
 		BinaryBase64       string
 		SourceHTMLDocument string
 		WASMExecJS         template.JS
+		// ContentSecurityPolicy is the policy of the run document.
+		ContentSecurityPolicy string
 	}
 	RunFailure struct {
 		BuildLogs string
@@ -34,52 +38,59 @@ type (
 	}
 )
 
-func (dir *FilesystemDirectory) buildWASM(ctx context.Context, env []string, goExecPath string) (string, error) {
-	const output = "main.wasm"
-	buildArgs := []string{
-		"build",
-		"-o", output,
+func (dir *FilesystemDirectory) buildWASM(ctx context.Context, goEnv goEnvironment, goExecPath string) ([]byte, error) {
+	return dir.build(ctx, goEnv, goEnvOverride(), goExecPath, "main.wasm",
 		fmt.Sprintf("-gcflags=-trimpath=%s", dir.TempDir),
 		fmt.Sprintf("-asmflags=-trimpath=%s", dir.TempDir),
+	)
+}
+
+// build runs go build with flags on the selected main package, writing the
+// executable to output in the temporary directory, and returns its content.
+// The variables in override, like GOOS, are set in the environment. The
+// module graph of the package is checked against the policy first, since the
+// go.mod files may leave out indirect requirements the go command adds.
+func (dir *FilesystemDirectory) build(ctx context.Context, goEnv goEnvironment, override []string, goExecPath, output string, flags ...string) ([]byte, error) {
+	if err := dir.checkModuleGraph(ctx, goEnv, override, goExecPath, dir.mainPackage()); err != nil {
+		return nil, err
 	}
-	err := dir.execGo(ctx, env, goExecPath, buildArgs...)
+	buildArgs := append([]string{"build", "-o", output}, flags...)
+	buildArgs = append(buildArgs, dir.mainPackage())
+	err := dir.execGo(ctx, goEnv, override, goExecPath, buildArgs...)
 	if err != nil {
-		return "", errors.New(dir.Output.String())
+		return nil, errors.New(dir.Output.String())
 	}
-	wasmBuild, err := os.ReadFile(filepath.Join(dir.TempDir, output))
+	executable, err := os.ReadFile(filepath.Join(dir.TempDir, output))
 	if err != nil {
-		return "", fmt.Errorf("failed to open build file: %w", err)
+		return nil, fmt.Errorf("failed to open build file: %w", err)
 	}
-	encodedBuild := base64.StdEncoding.EncodeToString(wasmBuild)
-	return encodedBuild, nil
+	return executable, nil
 }
 
-func handleDownload(res http.ResponseWriter, req *http.Request) {
-	dir, err := readMemoryDirectory(req)
+// readWASMExecJS reads the wasm_exec.js support script that matches the
+// toolchain at goExecPath from its GOROOT.
+func readWASMExecJS(ctx context.Context, goExecPath string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, goExecPath, "env", "GOROOT")
+	cmd.Env = mergeEnv(os.Environ(), goEnvOverride()...)
+	out, err := cmd.Output()
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
-		return
+		return nil, fmt.Errorf("failed to find GOROOT: %w", err)
 	}
-	dir.ServeHTTP(res, req)
-}
-
-func handleRun(goExecPath string) http.HandlerFunc {
-	env := mergeEnv(os.Environ(), goEnvOverride()...)
-
-	wasmExecJS, err := fs.ReadFile(assets, "assets/lib/wasm_exec.js")
-	if err != nil {
-		fmt.Println(err)
-		fs.WalkDir(assets, ".", func(path string, d fs.DirEntry, err error) error {
-			if d.IsDir() || err != nil {
-				return err
-			}
-			fmt.Println("found: ", path)
-			return nil
-		})
-		os.Exit(0)
+	goroot := strings.TrimSpace(string(out))
+	buf, err := os.ReadFile(filepath.Join(goroot, "lib", "wasm", "wasm_exec.js"))
+	if errors.Is(err, fs.ErrNotExist) {
+		// Go 1.23 and earlier kept the script in misc.
+		buf, err = os.ReadFile(filepath.Join(goroot, "misc", "wasm", "wasm_exec.js"))
 	}
+	return buf, err
+}
 
+func handleRun(goExecPath string, goEnv goEnvironment, wasmExecJS []byte, queue buildQueue, security securityPolicy, timeout time.Duration) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
+		if err := parseRequestForm(req); err != nil {
+			writeRequestError(res, req, err)
+			return
+		}
 		var runID = 1
 		if runIDQuery := req.FormValue("run-id"); runIDQuery != "" {
 			var err error
@@ -90,7 +101,7 @@ func handleRun(goExecPath string) http.HandlerFunc {
 			}
 		}
 
-		ctx, cancel := context.WithTimeout(req.Context(), time.Second*30)
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
 		defer cancel()
 
 		currentURL, err := url.Parse(req.Header.Get("hx-current-url"))
@@ -106,15 +117,23 @@ func handleRun(goExecPath string) http.HandlerFunc {
 
 		dir, err := newRequestDirectory(req)
 		if err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
+			writeRequestError(res, req, err)
 			return
 		}
 		defer func() {
 			_ = dir.close()
 		}()
 
-		buildBase64, err := dir.buildWASM(ctx, env, goExecPath)
+		release, err := queue.acquire(ctx)
 		if err != nil {
+			writeAcquireError(res, err)
+			return
+		}
+		defer release()
+
+		wasmBuild, err := dir.buildWASM(ctx, goEnv, goExecPath)
+		if err != nil {
+			auditOutcome(req.Context(), "build failed")
 			renderHTML(res, req, http.StatusOK, func(w io.Writer) error {
 				return templates.ExecuteTemplate(w, "build-failure", RunFailure{
 					RunID:     runID,
@@ -127,8 +146,10 @@ func handleRun(goExecPath string) http.HandlerFunc {
 		data := Run{
 			Location:     fmt.Sprintf("%s://%s", currentURL.Scheme, currentURL.Host),
 			RunID:        runID,
-			BinaryBase64: buildBase64,
+			BinaryBase64: base64.StdEncoding.EncodeToString(wasmBuild),
 			WASMExecJS:   template.JS(wasmExecJS),
+
+			ContentSecurityPolicy: security.run(),
 		}
 
 		if req.Header.Get("HX-Target") == "runner" {
@@ -143,6 +164,8 @@ func handleRun(goExecPath string) http.HandlerFunc {
 				return templates.ExecuteTemplate(w, "run-item", data)
 			})
 		} else {
+			res.Header().Set("Content-Security-Policy", security.runDocument())
+			res.Header().Del("X-Frame-Options")
 			renderHTML(res, req, http.StatusOK, func(w io.Writer) error {
 				return templates.ExecuteTemplate(w, "run.html.template", data)
 			})
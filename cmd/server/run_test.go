@@ -1,13 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"os/exec"
 	"slices"
 	"testing"
 )
 
 func Test_permittedPackages(t *testing.T) {
-	perm := permittedPackages()
+	perm := embeddedPolicy().Packages
 	if slices.Contains(perm, "") {
 		t.Error("empty string not allowed")
 	}
 }
+
+func Test_readWASMExecJS(t *testing.T) {
+	goExecPath, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not found")
+	}
+	buf, err := readWASMExecJS(t.Context(), goExecPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf, []byte("globalThis.Go")) {
+		t.Error("expected wasm_exec.js to define the Go class")
+	}
+}
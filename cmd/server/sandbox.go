@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// sandboxShimArg is the first argument that makes the server run as the
+// sandbox shim, which applies resource limits to itself and then executes the
+// go command. See sandbox.command.
+const sandboxShimArg = "sandbox-exec"
+
+// sandboxQuotaInterval is how often the disk usage of a sandboxed command is
+// measured.
+const sandboxQuotaInterval = 250 * time.Millisecond
+
+// sandbox limits the resources go subprocesses may use. Zero fields apply no
+// limit. The resource limits, user, and network isolation are only supported
+// on Linux; see sandbox_linux.go. The disk quota works everywhere.
+type sandbox struct {
+	// UID and GID are the unprivileged user and group commands run as. The
+	// server must run as root to use them.
+	UID, GID int
+	// CPU limits the processor time of each process.
+	CPU time.Duration
+	// MemoryBytes limits the data segment, the heap, of each process.
+	MemoryBytes uint64
+	// Processes limits the number of processes the user may run, so it
+	// requires a dedicated UID.
+	Processes uint64
+	// FileSizeBytes limits the size of each file written.
+	FileSizeBytes uint64
+	// DiskQuotaBytes limits the total size of the project and home
+	// directories. Commands exceeding it are killed.
+	DiskQuotaBytes int64
+	// IsolateNetwork runs commands in a new network namespace without any
+	// network. Modules must then come from a file:// GOPROXY.
+	IsolateNetwork bool
+}
+
+// sandboxViolation is returned when a command is stopped because it exceeded
+// a limit.
+type sandboxViolation struct {
+	limit string
+}
+
+func (violation *sandboxViolation) Error() string {
+	return "build stopped: exceeded the " + violation.limit
+}
+
+// run starts cmd, kills it if the directories in dirs grow beyond the disk
+// quota, and waits for it. When cmd fails because it exceeded a limit the
+// error is a *sandboxViolation.
+func (sb sandbox) run(cmd *exec.Cmd, dirs ...string) error {
+	var stderr bytes.Buffer
+	if cmd.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, &stderr)
+	} else {
+		cmd.Stderr = &stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var quotaExceeded atomic.Bool
+	done := make(chan struct{})
+	if sb.DiskQuotaBytes > 0 {
+		go func() {
+			ticker := time.NewTicker(sandboxQuotaInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+				}
+				if diskUsage(dirs...) > sb.DiskQuotaBytes {
+					quotaExceeded.Store(true)
+					_ = killProcessGroup(cmd)
+					return
+				}
+			}
+		}()
+	}
+	err := cmd.Wait()
+	close(done)
+	if err == nil {
+		return nil
+	}
+	if quotaExceeded.Load() {
+		return &sandboxViolation{limit: fmt.Sprintf("disk quota of %d bytes", sb.DiskQuotaBytes)}
+	}
+	if limit := sb.exceededLimit(err, stderr.String()); limit != "" {
+		return &sandboxViolation{limit: limit}
+	}
+	return err
+}
+
+// exceededLimit describes the limit that made a command fail, judging by the
+// signal that stopped it or by the messages of the go command and its tools,
+// which may be stopped while the go command itself exits normally. Go
+// programs ignore SIGXFSZ, so a write past the file size limit fails with
+// "file too large" instead.
+func (sb sandbox) exceededLimit(err error, output string) string {
+	signal := exitSignal(err)
+	switch {
+	case sb.CPU > 0 && (signal == "CPU time limit exceeded" || strings.Contains(output, "CPU time limit exceeded")):
+		return fmt.Sprintf("CPU time limit of %s", sb.CPU)
+	case sb.FileSizeBytes > 0 && (signal == "file size limit exceeded" || strings.Contains(output, "file too large")):
+		return fmt.Sprintf("file size limit of %d bytes", sb.FileSizeBytes)
+	case sb.MemoryBytes > 0 && (strings.Contains(output, "out of memory") || strings.Contains(output, "cannot allocate memory")):
+		return fmt.Sprintf("memory limit of %d bytes", sb.MemoryBytes)
+	case sb.Processes > 0 && strings.Contains(output, "resource temporarily unavailable"):
+		return fmt.Sprintf("process limit of %d", sb.Processes)
+	}
+	return ""
+}
+
+func exitSignal(err error) string {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return ""
+	}
+	// The ProcessState string is like "signal: file size limit exceeded".
+	signal, ok := strings.CutPrefix(exitErr.ProcessState.String(), "signal: ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSuffix(signal, " (core dumped)")
+}
+
+// diskUsage returns the total size of the regular files in dirs.
+func diskUsage(dirs ...string) int64 {
+	var total int64
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+			return nil
+		})
+	}
+	return total
+}
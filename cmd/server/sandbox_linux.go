@@ -0,0 +1,148 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// check returns an error when the sandbox can not be applied by this process.
+func (sb sandbox) check() error {
+	if sb.Processes > 0 && sb.UID == 0 {
+		// RLIMIT_NPROC counts every process and thread of the user, which
+		// would include the server's own.
+		return errors.New("a process limit requires a dedicated sandbox user")
+	}
+	if (sb.UID > 0 || sb.IsolateNetwork) && os.Geteuid() != 0 {
+		return errors.New("running builds as another user or without network requires the server to run as root")
+	}
+	if sb.hasResourceLimits() {
+		if _, err := os.Executable(); err != nil {
+			return fmt.Errorf("resource limits require the server executable: %w", err)
+		}
+	}
+	return nil
+}
+
+func (sb sandbox) hasResourceLimits() bool {
+	return sb.CPU > 0 || sb.MemoryBytes > 0 || sb.Processes > 0 || sb.FileSizeBytes > 0
+}
+
+// command returns a command running the go command with args. When resource
+// limits are set, the server executable is run as a shim that applies them
+// to itself with setrlimit and then executes the go command, since limits
+// are inherited. The command runs in its own process group so every process
+// it starts can be killed, as the sandbox user when one is set, and in a new
+// network namespace when the network is isolated.
+func (sb sandbox) command(ctx context.Context, goExecPath string, args ...string) (*exec.Cmd, error) {
+	name, argv := goExecPath, args
+	if sb.hasResourceLimits() {
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		name = exe
+		argv = []string{
+			sandboxShimArg,
+			// RLIMIT_CPU is in seconds, and rounding down could make a
+			// limit under a second 0, which is no limit.
+			"-cpu", strconv.FormatUint(uint64(math.Ceil(sb.CPU.Seconds())), 10),
+			"-memory", strconv.FormatUint(sb.MemoryBytes, 10),
+			"-processes", strconv.FormatUint(sb.Processes, 10),
+			"-file-size", strconv.FormatUint(sb.FileSizeBytes, 10),
+			"--", goExecPath,
+		}
+		argv = append(argv, args...)
+	}
+	cmd := exec.CommandContext(ctx, name, argv...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+	if sb.UID > 0 {
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:    uint32(sb.UID),
+			Gid:    uint32(cmp.Or(sb.GID, sb.UID)),
+			Groups: []uint32{},
+		}
+	}
+	if sb.IsolateNetwork {
+		cmd.SysProcAttr.Cloneflags = syscall.CLONE_NEWNET
+	}
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	return cmd, nil
+}
+
+// prepare gives the sandbox user ownership of dirs.
+func (sb sandbox) prepare(dirs ...string) error {
+	if sb.UID <= 0 {
+		return nil
+	}
+	uid, gid := sb.UID, cmp.Or(sb.GID, sb.UID)
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if err := filepath.WalkDir(dir, func(p string, _ fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(p, uid, gid)
+		}); err != nil {
+			return fmt.Errorf("failed to prepare sandbox: %w", err)
+		}
+	}
+	return nil
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// runSandboxShim applies the resource limits in args and then replaces the
+// process with the command following "--". It does not return.
+func runSandboxShim(args []string) {
+	flags := flag.NewFlagSet(sandboxShimArg, flag.ContinueOnError)
+	cpu := flags.Uint64("cpu", 0, "CPU time limit in seconds")
+	memory := flags.Uint64("memory", 0, "data segment limit in bytes")
+	processes := flags.Uint64("processes", 0, "process limit")
+	fileSize := flags.Uint64("file-size", 0, "file size limit in bytes")
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		os.Exit(126)
+	}
+	for _, limit := range []struct {
+		resource int
+		value    uint64
+		hard     uint64
+	}{
+		// The hard CPU limit is a second past the soft limit so the process
+		// gets SIGXCPU, with its clear message, before it is killed.
+		{resource: unix.RLIMIT_CPU, value: *cpu, hard: *cpu + 1},
+		{resource: unix.RLIMIT_DATA, value: *memory, hard: *memory},
+		{resource: unix.RLIMIT_NPROC, value: *processes, hard: *processes},
+		{resource: unix.RLIMIT_FSIZE, value: *fileSize, hard: *fileSize},
+	} {
+		if limit.value == 0 {
+			continue
+		}
+		if err := unix.Setrlimit(limit.resource, &unix.Rlimit{Cur: limit.value, Max: limit.hard}); err != nil {
+			fmt.Fprintln(os.Stderr, "sandbox:", err)
+			os.Exit(126)
+		}
+	}
+	path := flags.Arg(0)
+	err := syscall.Exec(path, flags.Args(), os.Environ())
+	fmt.Fprintln(os.Stderr, "sandbox:", err)
+	os.Exit(126)
+}
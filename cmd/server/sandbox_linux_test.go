@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSandbox_run_fileSizeLimit(t *testing.T) {
+	goExecPath, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not found")
+	}
+	dir := sandboxTestDirectory(t)
+	goEnv := goEnvironment{sandbox: sandbox{FileSizeBytes: 1 << 20}}
+	_, err = dir.build(t.Context(), goEnv, nil, goExecPath, "app")
+	if err == nil {
+		t.Fatal("expected the build to fail")
+	}
+	if !strings.Contains(dir.Output.String(), "exceeded the file size limit of 1048576 bytes") {
+		t.Errorf("expected the output to name the limit, got:\n%s", dir.Output.String())
+	}
+}
+
+func TestSandbox_check(t *testing.T) {
+	if err := (sandbox{Processes: 64}).check(); err == nil || !strings.Contains(err.Error(), "dedicated sandbox user") {
+		t.Errorf("expected a process limit without a sandbox user to be rejected, got %v", err)
+	}
+}
+
+func TestSandbox_command_cpuLimit(t *testing.T) {
+	cmd, err := (sandbox{CPU: 100 * time.Millisecond}).command(t.Context(), "go", "version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := slices.Index(cmd.Args, "-cpu")
+	if i < 0 || cmd.Args[i+1] != "1" {
+		t.Errorf("expected a CPU limit under a second to be rounded up to 1, got %q", cmd.Args)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// check returns an error when the sandbox can not be applied by this process.
+// Only the disk quota is supported outside Linux.
+func (sb sandbox) check() error {
+	if sb.UID > 0 || sb.IsolateNetwork || sb.CPU > 0 || sb.MemoryBytes > 0 || sb.Processes > 0 || sb.FileSizeBytes > 0 {
+		return fmt.Errorf("sandbox users, resource limits, and network isolation are not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
+
+func (sb sandbox) command(ctx context.Context, goExecPath string, args ...string) (*exec.Cmd, error) {
+	if err := sb.check(); err != nil {
+		return nil, err
+	}
+	return exec.CommandContext(ctx, goExecPath, args...), nil
+}
+
+func (sb sandbox) prepare(...string) error { return nil }
+
+func killProcessGroup(cmd *exec.Cmd) error { return cmd.Process.Kill() }
+
+func runSandboxShim([]string) {
+	fmt.Fprintln(os.Stderr, "sandbox:", errors.ErrUnsupported)
+	os.Exit(126)
+}
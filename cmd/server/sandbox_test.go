@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/txtar"
+)
+
+// TestMain lets the test binary act as the sandbox shim, as the server does,
+// since sandbox.command re-executes the running executable.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == sandboxShimArg {
+		runSandboxShim(os.Args[2:])
+	}
+	os.Exit(m.Run())
+}
+
+func sandboxTestDirectory(t *testing.T) *FilesystemDirectory {
+	t.Helper()
+	dir, err := newFilesystemDirectory(MemoryDirectory{Archive: txtar.Parse([]byte(`-- go.mod --
+module example.com/app
+
+go 1.25
+-- main.go --
+package main
+
+func main() {}
+`))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = dir.close() })
+	return &dir
+}
+
+func TestSandbox_run(t *testing.T) {
+	goExecPath, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not found")
+	}
+
+	t.Run("within limits", func(t *testing.T) {
+		dir := sandboxTestDirectory(t)
+		goEnv := goEnvironment{sandbox: sandbox{CPU: time.Minute, FileSizeBytes: 1 << 30, DiskQuotaBytes: 1 << 30}}
+		if err := dir.execGo(t.Context(), goEnv, nil, goExecPath, "env", "GOVERSION"); err != nil {
+			t.Fatal(err, dir.Output.String())
+		}
+	})
+
+	t.Run("disk quota", func(t *testing.T) {
+		dir := sandboxTestDirectory(t)
+		goEnv := goEnvironment{sandbox: sandbox{DiskQuotaBytes: 1}}
+		_, err := dir.build(t.Context(), goEnv, nil, goExecPath, "app")
+		if err == nil {
+			t.Fatal("expected the build to fail")
+		}
+		if !strings.Contains(dir.Output.String(), "exceeded the disk quota of 1 bytes") {
+			t.Errorf("expected the output to name the limit, got:\n%s", dir.Output.String())
+		}
+	})
+}
+
+func TestSandbox_exceededLimit(t *testing.T) {
+	sb := sandbox{CPU: 10 * time.Second, MemoryBytes: 1 << 20}
+	for _, tt := range []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{name: "cpu", output: "compile: signal: CPU time limit exceeded", want: "CPU time limit of 10s"},
+		{name: "memory", output: "fatal error: runtime: out of memory", want: "memory limit of 1048576 bytes"},
+		{name: "file size not limited", output: "link: signal: file size limit exceeded"},
+		{name: "compile error", output: "./main.go:3:1: syntax error"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sb.exceededLimit(errors.New("exit status 1"), tt.output); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
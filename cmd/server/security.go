@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// defaultLibrarySource is where index.html.template loads htmx and
+// CodeMirror from.
+const defaultLibrarySource = "https://cdnjs.cloudflare.com"
+
+// securityPolicy sets the Content-Security-Policy and related headers.
+//
+// The editor page may only run scripts with the nonce of its response, the
+// scripts of the run document, and scripts from ScriptSources. The run
+// document is loaded into a sandboxed iframe with srcdoc, so it inherits the
+// editor policy, and it adds a stricter policy of its own that only permits
+// its inline scripts, by hash, and loading the WebAssembly binary.
+type securityPolicy struct {
+	// ScriptSources and StyleSources are CSP sources, like
+	// "https://cdnjs.cloudflare.com", the editor loads libraries from. The
+	// server's own origin is always permitted.
+	ScriptSources []string
+	StyleSources  []string
+
+	// runScriptHashes are the CSP hash sources of the inline scripts of
+	// run.html.template.
+	runScriptHashes []string
+}
+
+// checkSources reports sources that would change the policy they are added
+// to, like keywords or directives.
+func checkSources(sources []string) error {
+	for _, src := range sources {
+		if strings.ContainsAny(src, ";'\" \t") {
+			return fmt.Errorf("invalid Content-Security-Policy source %q", src)
+		}
+	}
+	return nil
+}
+
+var inlineScriptPattern = regexp.MustCompile(`(?s)<script[^>]*>(.*?)</script>`)
+
+// withRunScripts returns policy permitting the inline scripts of the run
+// document, which only change with wasm_exec.js.
+func (policy securityPolicy) withRunScripts(wasmExecJS []byte) (securityPolicy, error) {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "run.html.template", Run{WASMExecJS: template.JS(wasmExecJS)}); err != nil {
+		return policy, err
+	}
+	policy.runScriptHashes = nil
+	for _, match := range inlineScriptPattern.FindAllSubmatch(buf.Bytes(), -1) {
+		sum := sha256.Sum256(match[1])
+		policy.runScriptHashes = append(policy.runScriptHashes, "'sha256-"+base64.StdEncoding.EncodeToString(sum[:])+"'")
+	}
+	return policy, nil
+}
+
+// editor returns the policy of the editor pages and fragments.
+func (policy securityPolicy) editor(nonce string) string {
+	nonceSource := "'nonce-" + nonce + "'"
+	return joinDirectives(
+		[]string{"default-src", "'self'"},
+		// The run document inherits this policy, so it permits running
+		// WebAssembly loaded from a data URL.
+		append(append([]string{"script-src", "'self'", nonceSource, "'wasm-unsafe-eval'"}, policy.runScriptHashes...), policy.ScriptSources...),
+		append([]string{"style-src", "'self'", nonceSource}, policy.StyleSources...),
+		[]string{"img-src", "'self'", "data:"},
+		[]string{"connect-src", "'self'", "data:"},
+		[]string{"object-src", "'none'"},
+		[]string{"base-uri", "'none'"},
+		[]string{"form-action", "'self'"},
+		[]string{"frame-ancestors", "'none'"},
+	)
+}
+
+// run returns the policy of the run document. It is used in a meta element,
+// so it may not have frame-ancestors or sandbox directives.
+func (policy securityPolicy) run() string {
+	return joinDirectives(
+		[]string{"default-src", "'none'"},
+		append([]string{"script-src", "'wasm-unsafe-eval'"}, policy.runScriptHashes...),
+		[]string{"connect-src", "data:"},
+		[]string{"base-uri", "'none'"},
+		[]string{"form-action", "'none'"},
+	)
+}
+
+// runDocument returns the header policy of a run document served on its own
+// rather than in the editor's iframe.
+func (policy securityPolicy) runDocument() string {
+	return joinDirectives(
+		[]string{policy.run()},
+		[]string{"frame-ancestors", "'self'"},
+		[]string{"sandbox", "allow-scripts"},
+	)
+}
+
+func joinDirectives(directives ...[]string) string {
+	list := make([]string, 0, len(directives))
+	for _, directive := range directives {
+		list = append(list, strings.Join(directive, " "))
+	}
+	return strings.Join(list, "; ")
+}
+
+// handler sets security headers on responses from next. Each request gets a
+// nonce for the inline scripts of the editor, see cspNonce. Handlers serving
+// other documents replace the Content-Security-Policy header.
+func (policy securityPolicy) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		nonce := rand.Text()
+		h := res.Header()
+		h.Set("Content-Security-Policy", policy.editor(nonce))
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "same-origin")
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+		next.ServeHTTP(res, req.WithContext(context.WithValue(req.Context(), cspNonceKey{}, nonce)))
+	})
+}
+
+type cspNonceKey struct{}
+
+// cspNonce returns the nonce inline scripts and styles of the response to a
+// request need to run.
+func cspNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"html"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+func Test_securityPolicy(t *testing.T) {
+	if err := checkSources([]string{"'self'", "https://cdn.example.com"}); err == nil {
+		t.Fatal("expected a quoted source to be rejected")
+	}
+	sources := []string{"https://cdn.example.com", "https://static.example.com"}
+	if err := checkSources(sources); err != nil {
+		t.Fatal(err)
+	}
+	wasmExecJS := []byte("globalThis.Go = class {};")
+	security, err := securityPolicy{ScriptSources: sources, StyleSources: sources}.withRunScripts(wasmExecJS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(wasmExecJS)
+	wasmExecHash := "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+	if len(security.runScriptHashes) != 2 || security.runScriptHashes[1] != wasmExecHash {
+		t.Fatalf("unexpected run script hashes %q", security.runScriptHashes)
+	}
+
+	t.Run("editor", func(t *testing.T) {
+		editor := security.handler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			renderIndex(res, req, "1.25", nil, Project{Dir: MemoryDirectory{Archive: &txtar.Archive{
+				Files: []txtar.File{{Name: "main.go", Data: []byte("package main\n")}},
+			}}})
+		}))
+		rec := httptest.NewRecorder()
+		editor.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+		}
+		policy := rec.Header().Get("Content-Security-Policy")
+		nonce := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(policy)
+		if nonce == nil {
+			t.Fatalf("expected a nonce in %q", policy)
+		}
+		if !strings.Contains(rec.Body.String(), `<script nonce="`+nonce[1]+`">`) {
+			t.Error("expected the inline script to have the nonce of the policy")
+		}
+		for _, want := range []string{"frame-ancestors 'none'", "https://static.example.com", wasmExecHash} {
+			if !strings.Contains(policy, want) {
+				t.Errorf("expected %q in policy %q", want, policy)
+			}
+		}
+		if strings.Contains(rec.Body.String(), "onload=") {
+			t.Error("inline event handlers do not run under the policy")
+		}
+		if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("got X-Content-Type-Options %q", got)
+		}
+
+		other := httptest.NewRecorder()
+		editor.ServeHTTP(other, httptest.NewRequest(http.MethodGet, "/", nil))
+		if other.Header().Get("Content-Security-Policy") == policy {
+			t.Error("expected each response to have a new nonce")
+		}
+	})
+
+	t.Run("run document", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := templates.ExecuteTemplate(&buf, "run.html.template", Run{
+			Location:              "http://localhost:8080",
+			RunID:                 3,
+			BinaryBase64:          base64.StdEncoding.EncodeToString([]byte("\x00asm")),
+			WASMExecJS:            template.JS(wasmExecJS),
+			ContentSecurityPolicy: security.run(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		meta := regexp.MustCompile(`http-equiv="Content-Security-Policy" content="([^"]*)"`).FindStringSubmatch(buf.String())
+		if meta == nil || html.UnescapeString(meta[1]) != security.run() {
+			t.Fatalf("expected the run policy in a meta element, got %q", meta)
+		}
+		for _, script := range inlineScriptPattern.FindAllSubmatch(buf.Bytes(), -1) {
+			sum := sha256.Sum256(script[1])
+			if hash := "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"; !strings.Contains(security.run(), hash) {
+				t.Errorf("expected the policy to permit script %.40q", script[1])
+			}
+		}
+		if !strings.Contains(security.runDocument(), "sandbox allow-scripts") {
+			t.Errorf("expected the standalone run document to be sandboxed, got %q", security.runDocument())
+		}
+	})
+}
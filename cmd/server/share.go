@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/tools/txtar"
+)
+
+const (
+	// maxShareEncodedBytes keeps share links short enough that their request
+	// line fits in the header limit, see minMaxHeaderBytes.
+	maxShareEncodedBytes = 6 << 10
+	// maxShareBytes limits the decompressed archive so a small link can not
+	// expand into a huge project.
+	maxShareBytes = 1 << 16
+)
+
+var errShareTooLarge = errors.New("project is too large to share as a link")
+
+// encodeShare compresses the txtar form of archive with deflate and encodes it
+// with unpadded base64url so it can be placed in a query parameter.
+func encodeShare(archive *txtar.Archive) (string, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(txtar.Format(archive)); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(buf.Bytes())
+	if len(encoded) > maxShareEncodedBytes {
+		return "", errShareTooLarge
+	}
+	return encoded, nil
+}
+
+func decodeShare(encoded string) (*txtar.Archive, error) {
+	if len(encoded) > maxShareEncodedBytes {
+		return nil, errShareTooLarge
+	}
+	compressed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid share link: %w", err)
+	}
+	r := flate.NewReader(bytes.NewReader(compressed))
+	defer closeAndIgnoreError(r)
+	buf, err := io.ReadAll(io.LimitReader(r, maxShareBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid share link: %w", err)
+	}
+	if len(buf) > maxShareBytes {
+		return nil, errShareTooLarge
+	}
+	return txtar.Parse(buf), nil
+}
+
+// shareSource imports projects encoded in a share link. References are the
+// value produced by encodeShare.
+func shareSource(_ context.Context, encoded string) (Project, error) {
+	archive, err := decodeShare(strings.TrimSpace(encoded))
+	if err != nil {
+		if errors.Is(err, errShareTooLarge) {
+			return Project{}, &importError{status: http.StatusRequestEntityTooLarge, message: err.Error()}
+		}
+		return Project{}, &importError{status: http.StatusBadRequest, message: err.Error()}
+	}
+	return newProject("Shared", archive)
+}
+
+func handleShare() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		dir, err := readMemoryDirectory(req)
+		if err != nil {
+			writeRequestError(res, req, err)
+			return
+		}
+		encoded, err := encodeShare(dir.Archive)
+		if err != nil {
+			if errors.Is(err, errShareTooLarge) {
+				http.Error(res, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		link := "/?" + url.Values{"share": {encoded}}.Encode()
+		renderHTML(res, req, http.StatusOK, func(w io.Writer) error {
+			return templates.ExecuteTemplate(w, "share-link", link)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+func Test_encodeShare(t *testing.T) {
+	archive := &txtar.Archive{Files: []txtar.File{
+		{Name: "go.mod", Data: []byte("module example.com\n\ngo 1.25\n")},
+		{Name: "main.go", Data: []byte("package main\n\nfunc main() {}\n")},
+	}}
+	encoded, err := encodeShare(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url.QueryEscape(encoded) != encoded {
+		t.Errorf("encoded share should not need escaping: %q", encoded)
+	}
+	decoded, err := decodeShare(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(txtar.Format(decoded)), string(txtar.Format(archive)); got != want {
+		t.Errorf("round trip mismatch:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_encodeShare_tooLarge(t *testing.T) {
+	noise := make([]byte, maxShareEncodedBytes)
+	_, _ = rand.Read(noise)
+	archive := &txtar.Archive{Files: []txtar.File{{Name: "data.txt", Data: noise}}}
+	if _, err := encodeShare(archive); !errors.Is(err, errShareTooLarge) {
+		t.Errorf("expected errShareTooLarge, got %v", err)
+	}
+}
+
+func Test_decodeShare_expansionLimit(t *testing.T) {
+	big := &txtar.Archive{Files: []txtar.File{{Name: "big.txt", Data: []byte(strings.Repeat("a", maxShareBytes))}}}
+	encoded, err := encodeShare(big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeShare(encoded); !errors.Is(err, errShareTooLarge) {
+		t.Errorf("expected errShareTooLarge, got %v", err)
+	}
+	if _, err := decodeShare("not base64!"); err == nil {
+		t.Error("expected an error for invalid input")
+	}
+}
+
+func Test_handleIndexPage_share(t *testing.T) {
+	sources := new(Sources)
+	sources.Register("share:", SourceFunc(shareSource))
+	handler := handleIndexPage("1.25", nil, sources)
+
+	encoded, err := encodeShare(&txtar.Archive{Files: []txtar.File{{Name: "main.go", Data: []byte("package main // shared\n")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?share="+encoded, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "package main // shared") {
+		t.Error("expected shared file in page")
+	}
+
+	hidden, err := encodeShare(&txtar.Archive{Files: []txtar.File{{Name: ".env", Data: []byte("SECRET=1\n")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?share="+hidden, nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected hidden file to be rejected, got status %d", rec.Code)
+	}
+}
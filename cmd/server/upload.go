@@ -0,0 +1,240 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/txtar"
+)
+
+const (
+	maxUploadBytes          = 1 << 22
+	maxUploadExtractedBytes = 1 << 24
+)
+
+var errUploadTooLarge = errors.New("upload is too large")
+
+func handleGETInstall(goVersion string) http.HandlerFunc {
+	type Data struct {
+		GoVersion       string
+		CopyrightNotice string
+	}
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		data := Data{
+			CopyrightNotice: fmt.Sprintf(CopyrightNotice, time.Now().Year()),
+			GoVersion:       goVersion,
+		}
+		renderHTML(res, req, http.StatusOK, func(w io.Writer) error {
+			return templates.ExecuteTemplate(w, "upload.html.template", data)
+		})
+	}
+}
+
+// handlePOSTInstall loads an uploaded project. The body may be a single
+// archive (zip, tar, tar.gz, or txtar), detected by content, or a multipart
+// form with one or more "file" parts holding an archive or loose files. The
+// legacy "zip" part name is accepted as well. When the "strip-root" field is
+// set, a single top-level directory shared by every file is removed. The size
+// of the body is limited by bodyLimits, to maxUploadBytes by default.
+func handlePOSTInstall(goVersion string, examples []Example) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		loadAndRender(res, req, goVersion, examples, func() (Project, error) {
+			project, err := readUpload(req)
+			if errors.Is(err, errUploadTooLarge) {
+				return Project{}, &importError{status: http.StatusRequestEntityTooLarge, message: fmt.Sprintf("extracted upload exceeds the %d byte limit", maxUploadExtractedBytes)}
+			}
+			return project, err
+		})
+	}
+}
+
+// readUpload returns the project in the body of an upload, see
+// handlePOSTInstall.
+func readUpload(req *http.Request) (Project, error) {
+	var (
+		archive   *txtar.Archive
+		stripRoot bool
+	)
+	if pr, err := req.MultipartReader(); err == nil {
+		var uploads []txtar.File
+		for {
+			part, err := pr.NextPart()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return Project{}, err
+			}
+			buf, err := io.ReadAll(part)
+			if err != nil {
+				return Project{}, err
+			}
+			switch part.FormName() {
+			case "strip-root":
+				stripRoot = string(buf) != ""
+			case "zip", "file":
+				if len(buf) == 0 && uploadFilename(part.Header.Get("Content-Disposition")) == "" {
+					continue // empty file input
+				}
+				uploads = append(uploads, txtar.File{
+					Name: uploadFilename(part.Header.Get("Content-Disposition")),
+					Data: buf,
+				})
+			default:
+				return Project{}, fmt.Errorf("unexpected form field %q", part.FormName())
+			}
+		}
+		switch len(uploads) {
+		case 0:
+			return Project{}, errors.New("no files uploaded")
+		case 1:
+			archive, err = readUploadArchive(uploads[0].Name, uploads[0].Data)
+		default:
+			archive = &txtar.Archive{Files: uploads}
+		}
+		if err != nil {
+			return Project{}, err
+		}
+	} else {
+		buf, err := io.ReadAll(req.Body)
+		if err != nil {
+			return Project{}, err
+		}
+		archive, err = readUploadArchive("", buf)
+		if err != nil {
+			return Project{}, err
+		}
+		stripRoot = req.URL.Query().Has("strip-root")
+	}
+
+	if stripRoot {
+		stripCommonRoot(archive)
+	}
+	archiveFS, err := txtar.FS(archive)
+	if err != nil {
+		return Project{}, err
+	}
+	dir, rejected, err := newMemoryDirectoryFromFS(archiveFS)
+	if err != nil {
+		return Project{}, err
+	}
+	return Project{Name: "Upload", Dir: dir, Rejected: rejected}, nil
+}
+
+// uploadFilename returns the cleaned filename parameter of a multipart
+// Content-Disposition header. Unlike multipart.Part.FileName it keeps the
+// directory, which browsers send for directory uploads.
+func uploadFilename(contentDisposition string) string {
+	_, params, err := mime.ParseMediaType(contentDisposition)
+	if err != nil || params["filename"] == "" {
+		return ""
+	}
+	return path.Clean("/" + strings.ReplaceAll(params["filename"], "\\", "/"))[1:]
+}
+
+// readUploadArchive detects the format of buf by its content and returns the
+// regular files it contains. Content that is not a zip, tar, gzipped tar, or
+// txtar is treated as a single file called name.
+func readUploadArchive(name string, buf []byte) (*txtar.Archive, error) {
+	switch {
+	case bytes.HasPrefix(buf, []byte("PK\x03\x04")), bytes.HasPrefix(buf, []byte("PK\x05\x06")):
+		return readZipArchive(buf)
+	case bytes.HasPrefix(buf, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(bytes.NewReader(buf))
+		if err != nil {
+			return nil, err
+		}
+		defer closeAndIgnoreError(gz)
+		return readTarArchive(gz)
+	case len(buf) > 262 && string(buf[257:262]) == "ustar":
+		return readTarArchive(bytes.NewReader(buf))
+	}
+	switch ext := strings.ToLower(path.Ext(name)); {
+	case ext == ".txtar", ext == ".txt" && len(txtar.Parse(buf).Files) > 0, name == "":
+		return txtar.Parse(buf), nil
+	}
+	return &txtar.Archive{Files: []txtar.File{{Name: name, Data: buf}}}, nil
+}
+
+func readZipArchive(buf []byte) (*txtar.Archive, error) {
+	zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		return nil, err
+	}
+	archive := new(txtar.Archive)
+	var total uint64
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		total += f.UncompressedSize64
+		if total > maxUploadExtractedBytes {
+			return nil, errUploadTooLarge
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, int64(f.UncompressedSize64)))
+		closeAndIgnoreError(rc)
+		if err != nil {
+			return nil, err
+		}
+		archive.Files = append(archive.Files, txtar.File{Name: f.Name, Data: data})
+	}
+	return archive, nil
+}
+
+func readTarArchive(r io.Reader) (*txtar.Archive, error) {
+	archive := new(txtar.Archive)
+	var total int64
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return archive, nil
+			}
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		total += header.Size
+		if total > maxUploadExtractedBytes {
+			return nil, errUploadTooLarge
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, header.Size))
+		if err != nil {
+			return nil, err
+		}
+		archive.Files = append(archive.Files, txtar.File{Name: header.Name, Data: data})
+	}
+}
+
+// stripCommonRoot removes the top-level directory from every file name when
+// all files share it, as in the source archives GitHub produces.
+func stripCommonRoot(archive *txtar.Archive) {
+	var root string
+	for i, file := range archive.Files {
+		dir, _, ok := strings.Cut(strings.TrimPrefix(file.Name, "./"), "/")
+		if !ok || (i > 0 && dir != root) {
+			return
+		}
+		root = dir
+	}
+	for i := range archive.Files {
+		_, archive.Files[i].Name, _ = strings.Cut(strings.TrimPrefix(archive.Files[i].Name, "./"), "/")
+	}
+}
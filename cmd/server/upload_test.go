@@ -0,0 +1,126 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+func archiveNames(archive *txtar.Archive) []string {
+	names := make([]string, 0, len(archive.Files))
+	for _, f := range archive.Files {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func Test_readUploadArchive(t *testing.T) {
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	for _, name := range []string{"project/go.mod", "project/main.go"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte("package main\n"))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	if err := tw.WriteHeader(&tar.Header{Name: "main.go", Mode: 0o644, Size: 13, Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = tw.Write([]byte("package main\n"))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tarGz := repositoryTarball(t, map[string]string{"root/go.mod": "module example.com\n", "root/main.go": "package main\n"})
+
+	for _, tt := range []struct {
+		name     string
+		filename string
+		buf      []byte
+		want     []string
+	}{
+		{name: "zip", filename: "project.zip", buf: zipBuf.Bytes(), want: []string{"project/go.mod", "project/main.go"}},
+		{name: "tar", filename: "project.tar", buf: tarBuf.Bytes(), want: []string{"main.go"}},
+		{name: "tar.gz", filename: "upload", buf: tarGz.Bytes(), want: []string{"root/go.mod", "root/main.go"}},
+		{name: "txtar", filename: "project.txtar", buf: []byte("-- go.mod --\nmodule example.com\n-- main.go --\npackage main\n"), want: []string{"go.mod", "main.go"}},
+		{name: "raw txtar", filename: "", buf: []byte("-- main.go --\npackage main\n"), want: []string{"main.go"}},
+		{name: "loose file", filename: "main.go", buf: []byte("package main\n"), want: []string{"main.go"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, err := readUploadArchive(tt.filename, tt.buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := archiveNames(archive); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_stripCommonRoot(t *testing.T) {
+	archive := &txtar.Archive{Files: []txtar.File{{Name: "repo-main/go.mod"}, {Name: "repo-main/cmd/main.go"}}}
+	stripCommonRoot(archive)
+	if got, want := archiveNames(archive), []string{"go.mod", "cmd/main.go"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	mixed := &txtar.Archive{Files: []txtar.File{{Name: "a/go.mod"}, {Name: "b/main.go"}}}
+	stripCommonRoot(mixed)
+	if got, want := archiveNames(mixed), []string{"a/go.mod", "b/main.go"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func Test_handlePOSTInstall_directory(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, content := range map[string]string{
+		"project/go.mod":      "module example.com\n",
+		"project/main.go":     "package main\n",
+		"project/.env":        "SECRET=1\n",
+		"project/pkg/util.go": "package pkg\n",
+	} {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", `form-data; name="file"; filename="`+name+`"`)
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	_ = mw.WriteField("strip-root", "on")
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handlePOSTInstall("1.25", nil).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	page := rec.Body.String()
+	for _, want := range []string{`data-file="go.mod"`, `data-file="pkg/util.go"`, "<code>.env</code>"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected page to contain %q", want)
+		}
+	}
+}
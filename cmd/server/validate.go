@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"golang.org/x/tools/txtar"
+)
+
+// Limits on the archives accepted from any entry point: the editor form,
+// pasted txtar, uploads, and imports. They apply after nested txtar files are
+// expanded.
+const (
+	maxArchiveFiles     = 256
+	maxArchiveFileBytes = 1 << 20
+	maxArchiveBytes     = 1 << 22
+	// maxArchiveDepth is the number of directories a file may be nested in.
+	maxArchiveDepth = 16
+)
+
+var (
+	errInvalidPath        = errors.New("file name must be a clean relative path")
+	errFileNotPermitted   = errors.New("file not permitted")
+	errDuplicateFile      = errors.New("duplicate file")
+	errTooManyFiles       = errors.New("too many files")
+	errFileTooLarge       = errors.New("file is too large")
+	errArchiveTooLarge    = errors.New("archive is too large")
+	errArchiveTooDeep     = errors.New("file is nested too deeply")
+	errNestedTxtarInTxtar = errors.New("nested txtar files may not contain txtar files")
+)
+
+// ArchiveError describes why an archive was rejected. File is empty when the
+// error is about the archive as a whole. Err is one of the errors above, so
+// callers can use errors.Is to tell them apart.
+type ArchiveError struct {
+	File   string
+	Err    error
+	Detail string
+}
+
+func (err *ArchiveError) Error() string {
+	msg := err.Err.Error()
+	if err.Detail != "" {
+		msg += " (" + err.Detail + ")"
+	}
+	if err.File == "" {
+		return msg
+	}
+	return fmt.Sprintf("%s: %s", err.File, msg)
+}
+
+func (err *ArchiveError) Unwrap() error { return err.Err }
+
+// checkArchive returns an *ArchiveError for the first file that would be
+// unsafe to write to disk or that exceeds a limit. Names must be clean,
+// slash separated, relative paths of permitted files, and no name may be
+// used twice, either as a file or as a directory of another file.
+func checkArchive(archive *txtar.Archive) error {
+	if archive == nil {
+		return nil
+	}
+	if n := len(archive.Files); n > maxArchiveFiles {
+		return &ArchiveError{Err: errTooManyFiles, Detail: fmt.Sprintf("%d files, the limit is %d", n, maxArchiveFiles)}
+	}
+	files := make(map[string]bool, len(archive.Files))
+	dirs := make(map[string]bool)
+	total := 0
+	for _, file := range archive.Files {
+		if err := checkArchiveFileName(file.Name); err != nil {
+			return err
+		}
+		if files[file.Name] || dirs[file.Name] {
+			return &ArchiveError{File: file.Name, Err: errDuplicateFile}
+		}
+		files[file.Name] = true
+		for d := path.Dir(file.Name); d != "."; d = path.Dir(d) {
+			if files[d] {
+				return &ArchiveError{File: d, Err: errDuplicateFile, Detail: "it is also a directory"}
+			}
+			dirs[d] = true
+		}
+		if n := len(file.Data); n > maxArchiveFileBytes {
+			return &ArchiveError{File: file.Name, Err: errFileTooLarge, Detail: fmt.Sprintf("%d bytes, the limit is %d", n, maxArchiveFileBytes)}
+		}
+		total += len(file.Data)
+		if total > maxArchiveBytes {
+			return &ArchiveError{Err: errArchiveTooLarge, Detail: fmt.Sprintf("more than %d bytes", maxArchiveBytes)}
+		}
+	}
+	return nil
+}
+
+// checkArchiveFileName checks a single name the way checkArchive does.
+func checkArchiveFileName(name string) error {
+	if !fs.ValidPath(name) || name == "." || strings.ContainsAny(name, "\\:\x00") {
+		return &ArchiveError{File: name, Err: errInvalidPath}
+	}
+	if depth := strings.Count(name, "/"); depth > maxArchiveDepth {
+		return &ArchiveError{File: name, Err: errArchiveTooDeep, Detail: fmt.Sprintf("the limit is %d directories", maxArchiveDepth)}
+	}
+	if !isPermittedFile(name) {
+		return &ArchiveError{File: name, Err: errFileNotPermitted}
+	}
+	return nil
+}
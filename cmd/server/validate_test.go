@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+func Test_checkArchive(t *testing.T) {
+	manyFiles := new(txtar.Archive)
+	for i := range maxArchiveFiles + 1 {
+		manyFiles.Files = append(manyFiles.Files, txtar.File{Name: strings.Repeat("a", i+1) + ".go"})
+	}
+	for _, tt := range []struct {
+		name    string
+		archive *txtar.Archive
+		want    error
+	}{
+		{name: "ok", archive: txtar.Parse([]byte("-- go.mod --\n-- main.go --\n-- cmd/app/main.go --\n"))},
+		{name: "parent directory", archive: txtar.Parse([]byte("-- a/../../x.go --\n")), want: errInvalidPath},
+		{name: "absolute", archive: txtar.Parse([]byte("-- /abs.go --\n")), want: errInvalidPath},
+		{name: "unclean", archive: txtar.Parse([]byte("-- a//b.go --\n")), want: errInvalidPath},
+		{name: "backslash", archive: txtar.Parse([]byte("-- a\\..\\b.go --\n")), want: errInvalidPath},
+		{name: "not permitted", archive: txtar.Parse([]byte("-- run.sh --\n")), want: errFileNotPermitted},
+		{name: "duplicate", archive: txtar.Parse([]byte("-- main.go --\n-- main.go --\n")), want: errDuplicateFile},
+		{name: "file and directory", archive: txtar.Parse([]byte("-- a.go --\n-- a.go/b.go --\n")), want: errDuplicateFile},
+		{name: "too deep", archive: &txtar.Archive{Files: []txtar.File{{Name: strings.Repeat("a/", maxArchiveDepth+1) + "main.go"}}}, want: errArchiveTooDeep},
+		{name: "too many files", archive: manyFiles, want: errTooManyFiles},
+		{name: "file too large", archive: &txtar.Archive{Files: []txtar.File{{Name: "main.go", Data: make([]byte, maxArchiveFileBytes+1)}}}, want: errFileTooLarge},
+		{name: "archive too large", archive: &txtar.Archive{Files: []txtar.File{
+			{Name: "a.go", Data: make([]byte, maxArchiveFileBytes)},
+			{Name: "b.go", Data: make([]byte, maxArchiveFileBytes)},
+			{Name: "c.go", Data: make([]byte, maxArchiveFileBytes)},
+			{Name: "d.go", Data: make([]byte, maxArchiveFileBytes)},
+			{Name: "e.go", Data: []byte("x")},
+		}}, want: errArchiveTooLarge},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkArchive(tt.archive)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			var archiveErr *ArchiveError
+			if tt.want != nil && !errors.As(err, &archiveErr) {
+				t.Errorf("expected an *ArchiveError, got %T", err)
+			}
+		})
+	}
+}
+
+func Test_expandNestedTxtar(t *testing.T) {
+	dir := MemoryDirectory{Archive: &txtar.Archive{Files: []txtar.File{
+		{Name: "main.go"},
+		{Name: "lib.txtar", Data: []byte("-- go.mod --\n-- lib.go --\n")},
+	}}}
+	if err := dir.expandAndCheckArchive(); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range dir.Archive.Files {
+		names = append(names, file.Name)
+	}
+	if got, want := strings.Join(names, ","), "main.go,lib/go.mod,lib/lib.go"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	for _, tt := range []struct {
+		name string
+		data string
+		want error
+	}{
+		{name: "escape", data: "-- ../../x.go --\n", want: errInvalidPath},
+		{name: "nested txtar", data: "-- more.txtar --\n", want: errNestedTxtarInTxtar},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := MemoryDirectory{Archive: &txtar.Archive{Files: []txtar.File{{Name: "lib.txtar", Data: []byte(tt.data)}}}}
+			if err := dir.expandAndCheckArchive(); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// checkLoadedArchive fails when an archive that passed validation could not
+// be written to disk safely.
+func checkLoadedArchive(t *testing.T, archive *txtar.Archive) {
+	t.Helper()
+	if len(archive.Files) > maxArchiveFiles {
+		t.Fatalf("loaded %d files", len(archive.Files))
+	}
+	for _, file := range archive.Files {
+		if !fs.ValidPath(file.Name) || !isPermittedFile(file.Name) {
+			t.Fatalf("loaded file with name %q", file.Name)
+		}
+	}
+	if _, err := txtar.FS(archive); err != nil {
+		t.Fatalf("loaded an archive txtar.FS rejects: %v", err)
+	}
+}
+
+func FuzzExpandNestedTxtar(f *testing.F) {
+	f.Add([]byte("-- main.go --\npackage main\n"), []byte("-- go.mod --\nmodule lib\n"))
+	f.Add([]byte(""), []byte("-- ../x.go --\n"))
+	f.Add([]byte(""), []byte("-- b.txtar --\n"))
+	f.Add([]byte("-- a/b.go --\n"), []byte("-- b.go --\n"))
+	f.Fuzz(func(t *testing.T, data, nested []byte) {
+		archive := txtar.Parse(data)
+		archive.Files = append(archive.Files, txtar.File{Name: "a.txtar", Data: nested})
+		dir := MemoryDirectory{Archive: archive}
+		if err := dir.expandAndCheckArchive(); err != nil {
+			var archiveErr *ArchiveError
+			if !errors.As(err, &archiveErr) {
+				t.Fatalf("expected an *ArchiveError, got %T: %v", err, err)
+			}
+			return
+		}
+		checkLoadedArchive(t, dir.Archive)
+	})
+}
+
+func FuzzReadMemoryDirectory(f *testing.F) {
+	f.Add("-- main.go --\npackage main\n", "main.go", "main.go")
+	f.Add("-- /etc/passwd.txt --\n", "", "")
+	f.Add("", "a/../../x.go", "a/../../x.go")
+	f.Add("", "go.mod", "go.mod,main.go,,go.mod")
+	f.Fuzz(func(t *testing.T, content, filename, openTabs string) {
+		form := url.Values{
+			"txtar-content": {content},
+			"filename":      {filename, filename},
+			filename:        {"package main\n"},
+			"open-tabs":     {openTabs},
+			"active-file":   {filename},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		dir, err := readMemoryDirectory(req)
+		if err != nil {
+			return
+		}
+		checkLoadedArchive(t, dir.Archive)
+		for _, name := range append(dir.OpenFiles, dir.ActiveFile) {
+			found := false
+			for _, file := range dir.Archive.Files {
+				found = found || file.Name == name
+			}
+			if !found && len(dir.Archive.Files) > 0 {
+				t.Fatalf("IDE state refers to %q, which is not in the archive", name)
+			}
+		}
+	})
+}
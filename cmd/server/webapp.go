@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/txtar"
+)
+
+// WebApp is the data for webapp.html.template, the page that loads main.wasm
+// in a downloaded web app.
+type WebApp struct {
+	Name string
+}
+
+// webAppStaticDir is the project directory whose files are copied to the root
+// of a downloaded web app. A static/index.html replaces the generated page.
+const webAppStaticDir = "static"
+
+// handleDownloadWebApp builds the project like handleRun does and responds
+// with a zip holding index.html, wasm_exec.js, main.wasm and the files in the
+// project's static directory, ready for any static file host.
+func handleDownloadWebApp(goExecPath string, goEnv goEnvironment, wasmExecJS []byte, queue buildQueue, timeout time.Duration) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+
+		dir, err := newRequestDirectory(req)
+		if err != nil {
+			writeRequestError(res, req, err)
+			return
+		}
+		defer func() {
+			_ = dir.close()
+		}()
+
+		release, err := queue.acquire(ctx)
+		if err != nil {
+			writeAcquireError(res, err)
+			return
+		}
+		defer release()
+
+		wasmBuild, err := dir.buildWASM(ctx, goEnv, goExecPath)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		archive, err := webAppArchive(dir.MemoryDirectory, wasmBuild, wasmExecJS)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		if err := writeZipArchive(&buf, archive); err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		filename := downloadFilename(dir.Name) + "-web.zip"
+		res.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		res.Header().Set("Content-Type", "application/zip")
+		http.ServeContent(res, req, filename, archiveModTime, bytes.NewReader(buf.Bytes()))
+	}
+}
+
+func webAppArchive(dir MemoryDirectory, wasmBuild, wasmExecJS []byte) (*txtar.Archive, error) {
+	archive := &txtar.Archive{Files: []txtar.File{
+		{Name: "main.wasm", Data: wasmBuild},
+		{Name: "wasm_exec.js", Data: wasmExecJS},
+	}}
+	hasIndex := false
+	for _, file := range dir.Archive.Files {
+		name, ok := strings.CutPrefix(file.Name, webAppStaticDir+"/")
+		if !ok {
+			continue
+		}
+		switch name {
+		case "main.wasm", "wasm_exec.js":
+			continue
+		case "index.html":
+			hasIndex = true
+		}
+		archive.Files = append(archive.Files, txtar.File{Name: name, Data: file.Data})
+	}
+	if !hasIndex {
+		var page bytes.Buffer
+		if err := templates.ExecuteTemplate(&page, "webapp.html.template", WebApp{Name: cmp.Or(dir.Name, "Playground")}); err != nil {
+			return nil, err
+		}
+		archive.Files = append(archive.Files, txtar.File{Name: "index.html", Data: page.Bytes()})
+	}
+	return archive, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+func Test_webAppArchive(t *testing.T) {
+	t.Run("generated index", func(t *testing.T) {
+		dir := MemoryDirectory{Name: "Counting Up", Archive: &txtar.Archive{Files: []txtar.File{
+			{Name: "go.mod", Data: []byte("module example.com\n")},
+			{Name: "main.go", Data: []byte("package main\n")},
+			{Name: "static/style.css", Data: []byte("body {}\n")},
+		}}}
+		archive, err := webAppArchive(dir, []byte("wasm"), []byte("js"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := archiveNames(archive), []string{"main.wasm", "wasm_exec.js", "style.css", "index.html"}; !slices.Equal(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		index := string(archive.Files[3].Data)
+		for _, want := range []string{"<title>Counting Up</title>", `src="wasm_exec.js"`, "main.wasm"} {
+			if !strings.Contains(index, want) {
+				t.Errorf("expected index.html to contain %q", want)
+			}
+		}
+	})
+
+	t.Run("project index", func(t *testing.T) {
+		dir := MemoryDirectory{Archive: &txtar.Archive{Files: []txtar.File{
+			{Name: "static/index.html", Data: []byte("<p>custom</p>\n")},
+			{Name: "static/main.wasm", Data: []byte("stale")},
+		}}}
+		archive, err := webAppArchive(dir, []byte("wasm"), []byte("js"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := archiveNames(archive), []string{"main.wasm", "wasm_exec.js", "index.html"}; !slices.Equal(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if string(archive.Files[0].Data) != "wasm" {
+			t.Error("project files must not replace the built main.wasm")
+		}
+	})
+}
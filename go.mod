@@ -4,10 +4,15 @@ go 1.26
 
 require (
 	github.com/chromedp/chromedp v0.16.0
+	github.com/coreos/go-oidc/v3 v3.21.0
 	github.com/crhntr/txtarfmt v0.4.4
+	github.com/go-jose/go-jose/v4 v4.1.4
 	github.com/google/go-github/v89 v89.0.0
 	github.com/testcontainers/testcontainers-go v0.43.0
+	golang.org/x/crypto v0.51.0
 	golang.org/x/mod v0.38.0
+	golang.org/x/oauth2 v0.36.0
+	golang.org/x/sys v0.47.0
 	golang.org/x/time v0.15.0
 	golang.org/x/tools v0.48.0
 )
@@ -69,7 +74,5 @@ require (
 	go.opentelemetry.io/otel/sdk v1.42.0 // indirect
 	go.opentelemetry.io/otel/sdk/metric v1.42.0 // indirect
 	go.opentelemetry.io/otel/trace v1.43.0 // indirect
-	golang.org/x/crypto v0.51.0 // indirect
-	golang.org/x/sys v0.47.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )